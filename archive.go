@@ -0,0 +1,360 @@
+package dbmigrat
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/hashicorp/go-multierror"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OpenArchive reads ".zip", ".tar.gz" or ".tgz" archive from disk and returns its content as fs.FS,
+// which might be passed to ReadDir, ReadRepos or ReadManifest funcs.
+// See ZipFS and TarGzFS funcs for details.
+func OpenArchive(archivePath string) (fs.FS, error) {
+	switch {
+	case strings.HasSuffix(archivePath, ".zip"):
+		f, err := os.Open(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		stat, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return ZipFS(f, stat.Size())
+	case strings.HasSuffix(archivePath, ".tar.gz"), strings.HasSuffix(archivePath, ".tgz"):
+		f, err := os.Open(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return TarGzFS(f)
+	default:
+		return nil, ErrArchiveFormat
+	}
+}
+
+// ZipFS reads whole zip archive into memory and returns its content as fs.FS.
+// Archive must not contain paths escaping its root (absolute or containing ".." element),
+// symbolic links and duplicated paths. Errors of all invalid entries are collected.
+// Archive (both compressed and decompressed) must not exceed 64 MiB and every decompressed file 8 MiB.
+func ZipFS(r io.ReaderAt, size int64) (fs.FS, error) {
+	if size > maxArchiveSize {
+		return nil, ErrArchiveSize
+	}
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	afs := newArchiveFS()
+	var errs *multierror.Error
+	var decompressedSize int64
+	for _, zipFile := range zipReader.File {
+		info := zipFile.FileInfo()
+		if info.Mode()&fs.ModeSymlink != 0 {
+			errs = multierror.Append(errs, errWithFileName{inner: ErrArchiveLink, fileName: zipFile.Name})
+			continue
+		}
+		var data []byte
+		if !info.IsDir() {
+			data, err = readZipFile(zipFile)
+			if err == ErrArchiveEntrySize {
+				errs = multierror.Append(errs, errWithFileName{inner: ErrArchiveEntrySize, fileName: zipFile.Name})
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		decompressedSize += int64(len(data))
+		if decompressedSize > maxArchiveSize {
+			return nil, ErrArchiveSize
+		}
+		err = afs.add(zipFile.Name, info.IsDir(), data, info.ModTime())
+		if err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	if errs != nil {
+		return nil, errs
+	}
+
+	return afs, nil
+}
+
+func readZipFile(zipFile *zip.File) ([]byte, error) {
+	rc, err := zipFile.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return readArchiveEntry(rc)
+}
+
+// readArchiveEntry reads decompressed file of archive. It returns ErrArchiveEntrySize
+// as soon as file exceeds maxArchiveEntrySize.
+func readArchiveEntry(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxArchiveEntrySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxArchiveEntrySize {
+		return nil, ErrArchiveEntrySize
+	}
+	return data, nil
+}
+
+// TarGzFS reads whole gzip compressed tar archive into memory and returns its content as fs.FS.
+// Archive must not contain paths escaping its root (absolute or containing ".." element),
+// links and duplicated paths. Errors of all invalid entries are collected.
+// Decompressed archive must not exceed 64 MiB and every file 8 MiB.
+func TarGzFS(r io.Reader) (fs.FS, error) {
+	gzipReader, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReader.Close()
+	tarReader := tar.NewReader(&sizeLimitedReader{r: gzipReader, remaining: maxArchiveSize})
+	afs := newArchiveFS()
+	var errs *multierror.Error
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = afs.add(header.Name, true, nil, header.ModTime)
+		case tar.TypeReg, tar.TypeRegA:
+			var data []byte
+			data, err = readArchiveEntry(tarReader)
+			if err == ErrArchiveEntrySize {
+				err = errWithFileName{inner: ErrArchiveEntrySize, fileName: header.Name}
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			err = afs.add(header.Name, false, data, header.ModTime)
+		case tar.TypeSymlink, tar.TypeLink:
+			err = errWithFileName{inner: ErrArchiveLink, fileName: header.Name}
+		default:
+			// pax headers and special files carry no migrations
+			continue
+		}
+		if err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	if errs != nil {
+		return nil, errs
+	}
+
+	return afs, nil
+}
+
+// sizeLimitedReader fails with ErrArchiveSize when more than remaining bytes are read.
+type sizeLimitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (lr *sizeLimitedReader) Read(p []byte) (int, error) {
+	if lr.remaining < 0 {
+		return 0, ErrArchiveSize
+	}
+	if int64(len(p)) > lr.remaining+1 {
+		p = p[:lr.remaining+1]
+	}
+	n, err := lr.r.Read(p)
+	lr.remaining -= int64(n)
+	if lr.remaining < 0 {
+		return n, ErrArchiveSize
+	}
+	return n, err
+}
+
+// archiveFS is read only, in memory fs.FS holding content of archive.
+// Parent directories missing in archive are added implicitly.
+type archiveFS struct {
+	entries map[string]*memEntry
+}
+
+func newArchiveFS() *archiveFS {
+	return &archiveFS{entries: map[string]*memEntry{
+		".": {name: ".", isDir: true, implicit: true},
+	}}
+}
+
+// add validates name of archive entry and adds entry together with its parent directories.
+func (afs *archiveFS) add(name string, isDir bool, data []byte, modTime time.Time) error {
+	cleanName, ok := cleanArchivePath(name)
+	if !ok {
+		return errWithFileName{inner: ErrArchivePath, fileName: name}
+	}
+	if existing, ok := afs.entries[cleanName]; ok {
+		if existing.implicit && isDir {
+			existing.implicit = false
+			existing.modTime = modTime
+			return nil
+		}
+		return errWithFileName{inner: ErrArchiveDuplicatedPath, fileName: name}
+	}
+	for dir := path.Dir(cleanName); dir != "."; dir = path.Dir(dir) {
+		parent, ok := afs.entries[dir]
+		if ok && !parent.isDir {
+			return errWithFileName{inner: ErrArchiveDuplicatedPath, fileName: name}
+		}
+		if !ok {
+			afs.entries[dir] = &memEntry{name: path.Base(dir), isDir: true, implicit: true}
+		}
+	}
+	afs.entries[cleanName] = &memEntry{name: path.Base(cleanName), isDir: isDir, data: data, modTime: modTime}
+	return nil
+}
+
+// cleanArchivePath converts name of archive entry to path accepted by fs.FS.
+// It returns false for paths escaping root of archive.
+func cleanArchivePath(name string) (string, bool) {
+	if strings.HasPrefix(name, "/") || strings.Contains(name, `\`) {
+		return "", false
+	}
+	for _, element := range strings.Split(name, "/") {
+		if element == ".." {
+			return "", false
+		}
+	}
+	cleanName := path.Clean(name)
+	return cleanName, cleanName != "." && fs.ValidPath(cleanName)
+}
+
+func (afs *archiveFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	entry, ok := afs.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if !entry.isDir {
+		return &memFile{entry: entry, reader: bytes.NewReader(entry.data)}, nil
+	}
+	return &memDir{entry: entry, children: afs.children(name)}, nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (afs *archiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	file, err := afs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := file.(*memDir)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return dir.ReadDir(-1)
+}
+
+// children returns sorted entries placed directly in dir.
+func (afs *archiveFS) children(dir string) []fs.DirEntry {
+	var children []fs.DirEntry
+	for entryPath, entry := range afs.entries {
+		if entryPath != "." && path.Dir(entryPath) == dir {
+			children = append(children, entry)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].Name() < children[j].Name() })
+	return children
+}
+
+// memEntry implements fs.FileInfo and fs.DirEntry of files held in memory (by archiveFS and gitFS).
+type memEntry struct {
+	name     string
+	isDir    bool
+	implicit bool
+	data     []byte
+	modTime  time.Time
+}
+
+func (e *memEntry) Name() string               { return e.name }
+func (e *memEntry) Size() int64                { return int64(len(e.data)) }
+func (e *memEntry) ModTime() time.Time         { return e.modTime }
+func (e *memEntry) IsDir() bool                { return e.isDir }
+func (e *memEntry) Sys() interface{}           { return nil }
+func (e *memEntry) Type() fs.FileMode          { return e.Mode().Type() }
+func (e *memEntry) Info() (fs.FileInfo, error) { return e, nil }
+func (e *memEntry) Mode() fs.FileMode {
+	if e.isDir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+type memFile struct {
+	entry  *memEntry
+	reader *bytes.Reader
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.entry, nil }
+func (f *memFile) Read(b []byte) (int, error) { return f.reader.Read(b) }
+func (f *memFile) Close() error               { return nil }
+
+type memDir struct {
+	entry    *memEntry
+	children []fs.DirEntry
+	offset   int
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.entry, nil }
+func (d *memDir) Close() error               { return nil }
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.entry.name, Err: errors.New("is a directory")}
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (d *memDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	remaining := d.children[d.offset:]
+	if count <= 0 {
+		d.offset = len(d.children)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+	d.offset += count
+	return remaining[:count], nil
+}
+
+// Errors reported by OpenArchive, ZipFS and TarGzFS funcs. They are wrapped with name of invalid entry
+// and collected in multierror, use errors.Is for checking them.
+var (
+	ErrArchiveFormat         = errors.New(`archive must have ".zip", ".tar.gz" or ".tgz" extension`)
+	ErrArchivePath           = errors.New("archive entry path escapes root of archive")
+	ErrArchiveLink           = errors.New("archive must not contain links")
+	ErrArchiveDuplicatedPath = errors.New("archive contains more than one entry with the same path")
+	ErrArchiveSize           = errors.New("archive exceeds 64 MiB")
+	ErrArchiveEntrySize      = errors.New("archive entry exceeds 8 MiB after decompression")
+)
+
+// Limits of archives, variables allow for testing them.
+var (
+	maxArchiveSize      int64 = 64 << 20
+	maxArchiveEntrySize int64 = 8 << 20
+)
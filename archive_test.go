@@ -0,0 +1,143 @@
+package dbmigrat
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-multierror"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var archiveFiles = []struct {
+	name string
+	data string
+}{
+	{name: "auth/0.create_user_table.up.sql", data: "create table users (id serial primary key);"},
+	{name: "auth/0.create_user_table.down.sql", data: "drop table users;"},
+	{name: "billing/0.create_orders.sql", data: "-- +dbmigrat Up\ncreate table orders (id serial primary key);\n-- +dbmigrat Down\ndrop table orders;"},
+}
+
+func TestZipFS(t *testing.T) {
+	t.Run("properly reads repos", func(t *testing.T) {
+		data := buildZip(t, []string{"auth/"}, archiveFiles)
+		fileSys, err := ZipFS(bytes.NewReader(data), int64(len(data)))
+		assert.NoError(t, err)
+		assert.NoError(t, fstest.TestFS(fileSys, "auth/0.create_user_table.up.sql", "billing/0.create_orders.sql"))
+		assertArchiveRepos(t, fileSys)
+	})
+	t.Run("returns error when path escapes root", func(t *testing.T) {
+		data := buildZip(t, nil, append(archiveFiles, struct{ name, data string }{name: "../evil.sql"}, struct{ name, data string }{name: "/etc/evil.sql"}))
+		fileSys, err := ZipFS(bytes.NewReader(data), int64(len(data)))
+		assert.EqualError(t, err, multierror.Append(
+			errWithFileName{inner: ErrArchivePath, fileName: "../evil.sql"},
+			errWithFileName{inner: ErrArchivePath, fileName: "/etc/evil.sql"},
+		).Error())
+		assert.Nil(t, fileSys)
+	})
+}
+
+func TestTarGzFS(t *testing.T) {
+	t.Run("properly reads repos", func(t *testing.T) {
+		fileSys, err := TarGzFS(bytes.NewReader(buildTarGz(t, archiveFiles, nil)))
+		assert.NoError(t, err)
+		assert.NoError(t, fstest.TestFS(fileSys, "auth/0.create_user_table.up.sql", "billing/0.create_orders.sql"))
+		assertArchiveRepos(t, fileSys)
+	})
+	t.Run("returns error for escaping paths, links and duplicates", func(t *testing.T) {
+		files := append(archiveFiles, struct{ name, data string }{name: "auth/../../evil.sql"}, archiveFiles[0])
+		fileSys, err := TarGzFS(bytes.NewReader(buildTarGz(t, files, map[string]string{"billing/link.sql": "/etc/passwd"})))
+		assert.EqualError(t, err, multierror.Append(
+			errWithFileName{inner: ErrArchivePath, fileName: "auth/../../evil.sql"},
+			errWithFileName{inner: ErrArchiveDuplicatedPath, fileName: "auth/0.create_user_table.up.sql"},
+			errWithFileName{inner: ErrArchiveLink, fileName: "billing/link.sql"},
+		).Error())
+		assert.Nil(t, fileSys)
+	})
+}
+
+func TestArchiveLimits(t *testing.T) {
+	defer func(size, entrySize int64) {
+		maxArchiveSize, maxArchiveEntrySize = size, entrySize
+	}(maxArchiveSize, maxArchiveEntrySize)
+
+	t.Run("returns error when entry exceeds limit", func(t *testing.T) {
+		maxArchiveSize, maxArchiveEntrySize = 8<<10, 32
+		files := append([]struct{ name, data string }{archiveFiles[1]}, struct{ name, data string }{name: "auth/1.large.sql", data: strings.Repeat("x", 33)})
+		data := buildZip(t, nil, files)
+		fileSys, err := ZipFS(bytes.NewReader(data), int64(len(data)))
+		assert.EqualError(t, err, multierror.Append(errWithFileName{inner: ErrArchiveEntrySize, fileName: "auth/1.large.sql"}).Error())
+		assert.Nil(t, fileSys)
+
+		fileSys, err = TarGzFS(bytes.NewReader(buildTarGz(t, files, nil)))
+		assert.EqualError(t, err, multierror.Append(errWithFileName{inner: ErrArchiveEntrySize, fileName: "auth/1.large.sql"}).Error())
+		assert.Nil(t, fileSys)
+	})
+	t.Run("returns error when archive exceeds limit", func(t *testing.T) {
+		maxArchiveSize, maxArchiveEntrySize = 8<<10, 8<<10
+		var files []struct{ name, data string }
+		for i := 0; i < 9; i++ {
+			files = append(files, struct{ name, data string }{name: fmt.Sprintf("auth/%d.part.sql", i), data: strings.Repeat("x", 1<<10)})
+		}
+		data := buildZip(t, nil, files)
+		fileSys, err := ZipFS(bytes.NewReader(data), int64(len(data)))
+		assert.Equal(t, ErrArchiveSize, err)
+		assert.Nil(t, fileSys)
+		fileSys, err = ZipFS(bytes.NewReader(data), maxArchiveSize+1)
+		assert.Equal(t, ErrArchiveSize, err)
+		assert.Nil(t, fileSys)
+
+		fileSys, err = TarGzFS(bytes.NewReader(buildTarGz(t, files, nil)))
+		assert.True(t, errors.Is(err, ErrArchiveSize))
+		assert.Nil(t, fileSys)
+	})
+}
+
+func assertArchiveRepos(t *testing.T, fileSys fs.FS) {
+	migrations, _, err := ReadRepos(fileSys, ".")
+	assert.NoError(t, err)
+	assert.Equal(t, Migrations{
+		"auth":    {{Description: "create_user_table", Up: "create table users (id serial primary key);", Down: "drop table users;"}},
+		"billing": {{Description: "create_orders", Up: "create table orders (id serial primary key);", Down: "drop table orders;"}},
+	}, migrations)
+}
+
+func buildZip(t *testing.T, dirs []string, files []struct{ name, data string }) []byte {
+	var buf bytes.Buffer
+	zipWriter := zip.NewWriter(&buf)
+	for _, dir := range dirs {
+		_, err := zipWriter.Create(dir)
+		assert.NoError(t, err)
+	}
+	for _, file := range files {
+		w, err := zipWriter.Create(file.name)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte(file.data))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, zipWriter.Close())
+	return buf.Bytes()
+}
+
+func buildTarGz(t *testing.T, files []struct{ name, data string }, symlinks map[string]string) []byte {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, file := range files {
+		assert.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.data)), Typeflag: tar.TypeReg}))
+		_, err := tarWriter.Write([]byte(file.data))
+		assert.NoError(t, err)
+	}
+	for name, target := range symlinks {
+		assert.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: name, Linkname: target, Typeflag: tar.TypeSymlink}))
+	}
+	assert.NoError(t, tarWriter.Close())
+	assert.NoError(t, gzipWriter.Close())
+	return buf.Bytes()
+}
@@ -0,0 +1,92 @@
+package dbmigrat
+
+import (
+	"errors"
+	"github.com/hashicorp/go-multierror"
+)
+
+// Baseline marks migrations as applied without running their Up SQL.
+// It allows for adopting dbmigrat on databases which schema has been created by hand or another tool.
+//
+// toIdx parameter is a map where keys are repositories names, values are indexes
+// of last migration (inclusive) which should be marked as applied.
+// Migrations already present in migrations log are skipped.
+//
+// All migrations marked by single call to Baseline get their own migration serial,
+// so subsequent calls to Migrate run only newer migrations.
+// Schema snapshot is captured the same way as by Migrate, so DetectDrift works right after Baseline.
+// On database tagged with protected Environment it requires WithConfirmation option.
+func Baseline(s store, migrations Migrations, toIdx map[Repo]int, opts ...Option) (int, error) {
+	err := s.begin()
+	if err != nil {
+		return 0, err
+	}
+	var logCount int
+	err = checkProtection(s, newOptions(opts))
+	if err == nil {
+		logCount, err = baseline(s, migrations, toIdx)
+	}
+	if err != nil {
+		return 0, multierror.Append(err, s.rollback())
+	}
+	return logCount, s.commit()
+}
+
+func baseline(s store, migrations Migrations, toIdx map[Repo]int) (int, error) {
+	lastMigrationSerial, err := s.fetchLastMigrationSerial()
+	if err != nil {
+		return 0, err
+	}
+	lastMigrationIndexes, err := s.fetchLastMigrationIndexes()
+	if err != nil {
+		return 0, err
+	}
+
+	repos := make([]Repo, 0, len(toIdx))
+	for repo := range toIdx {
+		repos = append(repos, repo)
+	}
+	sortRepos(repos)
+
+	var logs []MigrationLog
+	for _, repo := range repos {
+		idx := toIdx[repo]
+		if idx < 0 || idx >= len(migrations[repo]) {
+			return 0, errWithRepoIdx{inner: errBaselineMigrationNotFound, repo: repo, idx: idx}
+		}
+		lastMigrationIdx, ok := lastMigrationIndexes[repo]
+		if !ok {
+			lastMigrationIdx = -1
+		}
+		for i := lastMigrationIdx + 1; i <= idx; i++ {
+			logs = append(logs, MigrationLog{
+				Idx:             i,
+				Repo:            repo,
+				MigrationSerial: lastMigrationSerial + 1,
+				Checksum:        sha1Checksum(migrations[repo][i].Up),
+				Description:     migrations[repo][i].Description,
+				Version:         migrations[repo][i].Version,
+			})
+		}
+	}
+	if len(logs) == 0 {
+		return 0, nil
+	}
+
+	err = s.insertLogs(logs)
+	if err != nil {
+		return 0, err
+	}
+	snapshot, err := s.fetchSchemaSnapshot()
+	if err != nil {
+		return 0, err
+	}
+	err = s.insertSchemaSnapshot(lastMigrationSerial+1, snapshot)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(logs), nil
+}
+
+var errBaselineMigrationNotFound = errors.New("migration selected for baseline does not exist in passed migrations")
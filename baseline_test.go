@@ -0,0 +1,93 @@
+package dbmigrat
+
+import (
+	"github.com/hashicorp/go-multierror"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBaseline(t *testing.T) {
+	before := func(t *testing.T) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+	}
+
+	t.Run("marks migrations as applied without running them", func(t *testing.T) {
+		before(t)
+		// # Simulate schema created by hand
+		_, err := th.db.Exec(`create table users (id serial primary key, username varchar(32))`)
+		assert.NoError(t, err)
+
+		logCount, err := Baseline(th.pgStore, th.migrations2, map[Repo]int{"auth": 1})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, logCount)
+
+		var migrationLogs []MigrationLog
+		assert.NoError(t, th.db.Select(&migrationLogs, `select * from dbmigrat_log order by idx`))
+		assert.Len(t, migrationLogs, 2)
+		for i, log := range migrationLogs {
+			assert.Equal(t, i, log.Idx)
+			assert.Equal(t, Repo("auth"), log.Repo)
+			assert.Equal(t, 0, log.MigrationSerial)
+			assert.Equal(t, sha1Checksum(th.migrations2["auth"][i].Up), log.Checksum)
+			assert.Equal(t, th.migrations2["auth"][i].Description, log.Description)
+		}
+
+		// # Check if schema snapshot is recorded with baseline serial
+		res, err := DetectDrift(th.pgStore)
+		assert.NoError(t, err)
+		assert.Equal(t, &DriftResult{HasDrift: false, MigrationSerial: 0}, res)
+
+		// # Check if Migrate runs only migrations not covered by baseline
+		logCount, err = Migrate(th.pgStore, th.migrations2, RepoOrder{"auth", "billing", "delivery"})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, logCount)
+
+		checkRes, err := CheckLogTableIntegrity(th.pgStore, th.migrations2)
+		assert.NoError(t, err)
+		assert.False(t, checkRes.IsCorrupted)
+	})
+
+	t.Run("skips migrations already present in log", func(t *testing.T) {
+		before(t)
+		_, err := Migrate(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"})
+		assert.NoError(t, err)
+
+		logCount, err := Baseline(th.pgStore, th.migrations2, map[Repo]int{"auth": 1, "billing": 1})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, logCount)
+
+		serial, err := th.pgStore.fetchLastMigrationSerial()
+		assert.NoError(t, err)
+		assert.Equal(t, 1, serial)
+	})
+
+	t.Run("refuses to baseline migration not present in migrations", func(t *testing.T) {
+		before(t)
+
+		logCount, err := Baseline(th.pgStore, th.migrations1, map[Repo]int{"auth": 2})
+		assert.EqualError(t, err, multierror.Append(errWithRepoIdx{inner: errBaselineMigrationNotFound, repo: "auth", idx: 2}).Error())
+		assert.Equal(t, 0, logCount)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		before(t)
+
+		caseTable := caseTable{
+			{name: "tx begin fail", storeMock: errorStoreMock{wrapped: th.pgStore, errBegin: true}, errExpected: exampleErr},
+			{name: "fetchLastMigrationSerial fail", storeMock: errorStoreMock{wrapped: th.pgStore, errFetchLastMigrationSerial: true}, errExpected: exampleMultiErr},
+			{name: "fetchLastMigrationIndexes fail", storeMock: errorStoreMock{wrapped: th.pgStore, errFetchLastMigrationIndexes: true}, errExpected: exampleMultiErr},
+			{name: "insertLogs fail", storeMock: errorStoreMock{wrapped: th.pgStore, errInsertLogs: true}, errExpected: exampleMultiErr},
+			{name: "fetchSchemaSnapshot fail", storeMock: errorStoreMock{wrapped: th.pgStore, errFetchSchemaSnapshot: true}, errExpected: exampleMultiErr},
+			{name: "insertSchemaSnapshot fail", storeMock: errorStoreMock{wrapped: th.pgStore, errInsertSchemaSnapshot: true}, errExpected: exampleMultiErr},
+		}
+
+		for _, testCase := range caseTable {
+			t.Run(testCase.name, func(t *testing.T) {
+				logCount, err := Baseline(testCase.storeMock, th.migrations1, map[Repo]int{"auth": 0})
+				assert.EqualError(t, err, testCase.errExpected.Error())
+				assert.Equal(t, 0, logCount)
+			})
+		}
+	})
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/graaphscom/dbmigrat"
+	"github.com/jmoiron/sqlx"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// config holds flags of command. Common flags which are not passed are taken from environment variables.
+type config struct {
+	dsn        string
+	dir        string
+	repos      repoFlags
+	configPath string
+	env        string
+	fillGaps   bool
+	toSerial   int
+	singleFile bool
+	versioned  bool
+	recursive  bool
+	output     string
+	confirm    string
+	setEnv     string
+	protected  bool
+	verbose    bool
+
+	statementTimeout time.Duration
+	lockTimeout      time.Duration
+	stdin            io.Reader
+	stderr           io.Writer
+
+	// fromEnv contains names of flags taken from environment variables.
+	fromEnv map[string]bool
+	project *dbmigrat.Config
+}
+
+func newConfig(flagSet *flag.FlagSet) *config {
+	cfg := &config{}
+	flagSet.StringVar(&cfg.dsn, "dsn", "", "Postgres connection string (default $DBMIGRAT_DSN)")
+	flagSet.StringVar(&cfg.dir, "dir", "", "directory containing repos, see dbmigrat.ReadRepos (default $DBMIGRAT_DIR)")
+	flagSet.Var(&cfg.repos, "repo", "repo as name=directory, might be repeated (default $DBMIGRAT_REPOS, comma separated)")
+	flagSet.StringVar(&cfg.configPath, "config", "", "project config file, see dbmigrat.ReadConfig (default $DBMIGRAT_CONFIG)")
+	flagSet.StringVar(&cfg.env, "env", "", "environment declared in project config (default $DBMIGRAT_ENV)")
+	flagSet.BoolVar(&cfg.versioned, "versioned", false, "migrations files names are prefixed with versions, see dbmigrat.Versioned")
+	flagSet.BoolVar(&cfg.recursive, "recursive", false, "migrations files are read from subdirectories of repos, see dbmigrat.Recursive")
+	flagSet.BoolVar(&cfg.verbose, "verbose", false, "print every applied or rolled back migration and integrity problem")
+	flagSet.DurationVar(&cfg.statementTimeout, "statement-timeout", 0, "default statement_timeout of migrations (eg. 30s)")
+	flagSet.DurationVar(&cfg.lockTimeout, "lock-timeout", 0, "default lock_timeout of migrations (eg. 5s)")
+	return cfg
+}
+
+// applyEnv sets common flags which were not passed to values of their environment variables.
+// It must be called after parsing flags. Environment variables are not flag defaults,
+// so their values (eg. password in DSN) are not printed by usage message.
+// Values of environment variables give way to passed flags and project config (see validate).
+func (cfg *config) applyEnv(flagSet *flag.FlagSet) {
+	cfg.fromEnv = map[string]bool{}
+	passed := map[string]bool{}
+	flagSet.Visit(func(f *flag.Flag) {
+		passed[f.Name] = true
+	})
+	envFlags := []struct {
+		name   string
+		envVar string
+		target *string
+	}{
+		{name: "dsn", envVar: "DBMIGRAT_DSN", target: &cfg.dsn},
+		{name: "dir", envVar: "DBMIGRAT_DIR", target: &cfg.dir},
+		{name: "config", envVar: "DBMIGRAT_CONFIG", target: &cfg.configPath},
+		{name: "env", envVar: "DBMIGRAT_ENV", target: &cfg.env},
+	}
+	for _, envFlag := range envFlags {
+		if !passed[envFlag.name] {
+			*envFlag.target = os.Getenv(envFlag.envVar)
+			cfg.fromEnv[envFlag.name] = *envFlag.target != ""
+		}
+	}
+	if envRepos := os.Getenv("DBMIGRAT_REPOS"); envRepos != "" {
+		cfg.repos.fromEnv = strings.Split(envRepos, ",")
+	}
+}
+
+func (cfg *config) validate(needsMigrations bool) error {
+	if cfg.output != "" && cfg.output != outputText && cfg.output != outputJSON {
+		return fmt.Errorf("%w (%s)", errInvalidOutput, cfg.output)
+	}
+	passedRepos := (cfg.dir != "" && !cfg.fromEnv["dir"]) || len(cfg.repos.fromFlags) > 0
+	if cfg.fromEnv["config"] && passedRepos {
+		cfg.configPath = ""
+	}
+	if cfg.configPath != "" {
+		err := cfg.loadProject()
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.dsn == "" {
+		return errMissingDSN
+	}
+	if !needsMigrations {
+		return nil
+	}
+	if cfg.project != nil {
+		if passedRepos {
+			return errConfigAndRepos
+		}
+		return nil
+	}
+	repos := cfg.repos.values()
+	if cfg.dir != "" && !cfg.fromEnv["dir"] && len(cfg.repos.fromFlags) > 0 {
+		return errDirAndRepos
+	}
+	if cfg.dir == "" && len(repos) == 0 {
+		return errMissingRepos
+	}
+	seen := map[string]bool{}
+	for _, repo := range repos {
+		name, _, ok := splitRepo(repo)
+		if !ok {
+			return fmt.Errorf("%w (%s)", errInvalidRepo, repo)
+		}
+		if seen[name] {
+			return fmt.Errorf("%w (%s)", errDuplicatedRepoArg, name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// loadProject reads project config. DSN of environment passed with -env flag
+// is used unless -dsn flag is passed.
+func (cfg *config) loadProject() error {
+	project, err := dbmigrat.ReadConfig(os.DirFS(filepath.Dir(cfg.configPath)), filepath.Base(cfg.configPath))
+	if err != nil {
+		return err
+	}
+	cfg.project = project
+	if cfg.dsn != "" && (!cfg.fromEnv["dsn"] || cfg.env == "") {
+		return nil
+	}
+	if cfg.env == "" {
+		return errMissingEnv
+	}
+	cfg.dsn, err = project.DSN(cfg.env)
+	return err
+}
+
+// readMigrations reads repos declared in project config, repos passed with -repo flags (in order of flags)
+// or repos found in directory passed with -dir flag (in order of repo_order file or by names).
+// Files of repos passed with flags are read with options of -versioned and -recursive flags,
+// project config declares them in its options.
+func (cfg *config) readMigrations() (dbmigrat.Migrations, dbmigrat.RepoOrder, error) {
+	if cfg.project != nil {
+		return cfg.project.ReadMigrations()
+	}
+	var readOpts []dbmigrat.ReadOption
+	if cfg.versioned {
+		readOpts = append(readOpts, dbmigrat.Versioned())
+	}
+	if cfg.recursive {
+		readOpts = append(readOpts, dbmigrat.Recursive())
+	}
+	if len(cfg.repos.fromFlags) == 0 && cfg.dir != "" {
+		migrations, repoOrder, err := dbmigrat.ReadRepos(os.DirFS(cfg.dir), ".", readOpts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		if repoOrder == nil {
+			for repo := range migrations {
+				repoOrder = append(repoOrder, repo)
+			}
+			sortRepoOrder(repoOrder)
+		}
+		return migrations, repoOrder, nil
+	}
+
+	migrations := dbmigrat.Migrations{}
+	var repoOrder dbmigrat.RepoOrder
+	for _, repo := range cfg.repos.values() {
+		name, dir, _ := splitRepo(repo)
+		repoMigrations, err := dbmigrat.ReadDir(os.DirFS(dir), ".", readOpts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("repo %s: %w", name, err)
+		}
+		migrations[dbmigrat.Repo(name)] = repoMigrations
+		repoOrder = append(repoOrder, dbmigrat.Repo(name))
+	}
+	return migrations, repoOrder, nil
+}
+
+// store creates PostgresStore with timeouts of project config environment, replaced by timeouts flags.
+func (cfg *config) store(db *sqlx.DB) *dbmigrat.PostgresStore {
+	pgStore := &dbmigrat.PostgresStore{DB: db}
+	if cfg.project != nil {
+		pgStore.StatementTimeout, pgStore.LockTimeout = cfg.project.Timeouts(cfg.env)
+	}
+	if cfg.statementTimeout > 0 {
+		pgStore.StatementTimeout = cfg.statementTimeout
+	}
+	if cfg.lockTimeout > 0 {
+		pgStore.LockTimeout = cfg.lockTimeout
+	}
+	return pgStore
+}
+
+func (cfg *config) migrateOptions() []dbmigrat.Option {
+	var opts []dbmigrat.Option
+	if cfg.project != nil {
+		opts = cfg.project.MigrateOptions(cfg.env)
+	}
+	if cfg.fillGaps {
+		opts = append(opts, dbmigrat.WithGapFilling())
+	}
+	return append(opts, cfg.logOptions()...)
+}
+
+func (cfg *config) logOptions() []dbmigrat.Option {
+	if !cfg.verbose {
+		return nil
+	}
+	return []dbmigrat.Option{dbmigrat.WithLogger(textLogger{w: cfg.stderr})}
+}
+
+// textLogger prints events of dbmigrat as lines of message followed by key=value pairs.
+type textLogger struct {
+	w io.Writer
+}
+
+func (l textLogger) Info(msg string, args ...interface{}) {
+	l.print("", msg, args)
+}
+
+func (l textLogger) Warn(msg string, args ...interface{}) {
+	l.print("warning: ", msg, args)
+}
+
+func (l textLogger) Error(msg string, args ...interface{}) {
+	l.print("error: ", msg, args)
+}
+
+func (l textLogger) print(level, msg string, args []interface{}) {
+	var line strings.Builder
+	fmt.Fprintf(&line, "[dbmigrat] %s%s", level, msg)
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&line, " %v=%v", args[i], args[i+1])
+	}
+	fmt.Fprintln(l.w, line.String())
+}
+
+func sortRepoOrder(repoOrder dbmigrat.RepoOrder) {
+	sort.Slice(repoOrder, func(i, j int) bool { return repoOrder[i] < repoOrder[j] })
+}
+
+func splitRepo(repo string) (string, string, bool) {
+	parts := strings.SplitN(repo, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// repoFlags collects repeated -repo flags. Repos passed as flags replace ones from environment variable.
+type repoFlags struct {
+	fromEnv   []string
+	fromFlags []string
+}
+
+func (rf *repoFlags) values() []string {
+	if len(rf.fromFlags) > 0 {
+		return rf.fromFlags
+	}
+	return rf.fromEnv
+}
+
+func (rf *repoFlags) String() string {
+	if rf == nil {
+		return ""
+	}
+	return strings.Join(rf.values(), ",")
+}
+
+func (rf *repoFlags) Set(value string) error {
+	rf.fromFlags = append(rf.fromFlags, value)
+	return nil
+}
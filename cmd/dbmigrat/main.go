@@ -0,0 +1,453 @@
+// Command dbmigrat applies, rolls back and inspects migrations stored in directories.
+//
+// Usage:
+//
+//	dbmigrat <command> [flags]
+//
+// Commands:
+//
+//	init     create migrations log table
+//	up       apply pending migrations
+//	down     roll back migrations applied after serial passed with -to-serial (-1 rolls back all)
+//	env      show environment of database or tag it: dbmigrat env [-set <name>] [-protected]
+//	status   show applied and pending migrations of every repo
+//	verify   check integrity of migrations log
+//	plan     show migrations which would be applied by up
+//	new      create files of new migration: dbmigrat new [-single] [-versioned] [-recursive] <repo directory> <description>
+//
+// Flags (defaults are taken from environment variables, passed flags and project config take precedence over them):
+//
+//	-dsn     Postgres connection string (DBMIGRAT_DSN)
+//	-dir     directory read by dbmigrat.ReadRepos (DBMIGRAT_DIR)
+//	-repo    repo as name=directory, repeatable, order of flags is RepoOrder (DBMIGRAT_REPOS, comma separated)
+//	-config  project config file read by dbmigrat.ReadConfig, replaces -dir and -repo flags (DBMIGRAT_CONFIG)
+//	-env     environment of project config providing DSN and options (DBMIGRAT_ENV)
+//	-versioned, -recursive  read migrations files with dbmigrat.Versioned and dbmigrat.Recursive options,
+//	         new prefixes files names with current time (-versioned) or looks for the next index in subdirectories (-recursive)
+//	-verbose print every applied or rolled back migration and integrity problem to stderr
+//	-statement-timeout, -lock-timeout  defaults of migrations timeouts (see dbmigrat.PostgresStore),
+//	         they replace timeouts of project config (see dbmigrat.Config.Timeouts)
+//
+// Project config does not set flags of env command, -verbose, -confirm and -output flags, they are flag-only.
+//
+// On database tagged with protected environment, down and env -set ask for environment name
+// on standard input, unless it is passed with -confirm flag.
+//
+// Commands status, plan and verify accept -output json flag, which makes them print results
+// (dbmigrat.StatusResult, dbmigrat.PlanResult, dbmigrat.IntegrityCheckResult) as JSON.
+//
+// Exit codes: 0 on success, 1 on execution error, 2 on invalid usage or invalid migrations files,
+// 3 when verify finds corrupted migrations log.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/graaphscom/dbmigrat"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	exitOK        = 0
+	exitError     = 1
+	exitUsage     = 2
+	exitIntegrity = 3
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, usage)
+		return exitUsage
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n%s\n", args[0], usage)
+		return exitUsage
+	}
+
+	flagSet := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	flagSet.SetOutput(stderr)
+	cfg := newConfig(flagSet)
+	cfg.stdin = stdin
+	cfg.stderr = stderr
+	if command.flags != nil {
+		command.flags(flagSet, cfg)
+	}
+	err := flagSet.Parse(args[1:])
+	if err != nil {
+		return exitUsage
+	}
+	cfg.applyEnv(flagSet)
+	if command.runLocal != nil {
+		return command.runLocal(cfg, flagSet.Args(), stdout, stderr)
+	}
+	err = cfg.validate(command.needsMigrations)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	var migrations dbmigrat.Migrations
+	var repoOrder dbmigrat.RepoOrder
+	if command.needsMigrations {
+		migrations, repoOrder, err = cfg.readMigrations()
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitUsage
+		}
+	}
+
+	db, err := sqlx.Open("postgres", cfg.dsn)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	defer db.Close()
+
+	pgStore := cfg.store(db)
+	return command.run(pgStore, migrations, repoOrder, cfg, stdout, stderr)
+}
+
+// command is run against database (run) or locally, without connecting to database (runLocal).
+type command struct {
+	needsMigrations bool
+	flags           func(flagSet *flag.FlagSet, cfg *config)
+	run             func(pgStore *dbmigrat.PostgresStore, migrations dbmigrat.Migrations, repoOrder dbmigrat.RepoOrder, cfg *config, stdout, stderr io.Writer) int
+	runLocal        func(cfg *config, args []string, stdout, stderr io.Writer) int
+}
+
+var commands = map[string]command{
+	"init": {
+		run: func(pgStore *dbmigrat.PostgresStore, _ dbmigrat.Migrations, _ dbmigrat.RepoOrder, _ *config, stdout, stderr io.Writer) int {
+			err := pgStore.CreateLogTable()
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				return exitError
+			}
+			fmt.Fprintln(stdout, "[dbmigrat] created migrations log table")
+			return exitOK
+		},
+	},
+	"up": {
+		needsMigrations: true,
+		flags: func(flagSet *flag.FlagSet, cfg *config) {
+			flagSet.BoolVar(&cfg.fillGaps, "fill-gaps", false, "apply migrations missing in log below the last applied index")
+		},
+		run: func(pgStore *dbmigrat.PostgresStore, migrations dbmigrat.Migrations, repoOrder dbmigrat.RepoOrder, cfg *config, stdout, stderr io.Writer) int {
+			logsCount, err := dbmigrat.Migrate(pgStore, migrations, repoOrder, cfg.migrateOptions()...)
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				return exitError
+			}
+			fmt.Fprintf(stdout, "[dbmigrat] applied %d migrations\n", logsCount)
+			return exitOK
+		},
+	},
+	"down": {
+		needsMigrations: true,
+		flags: func(flagSet *flag.FlagSet, cfg *config) {
+			flagSet.IntVar(&cfg.toSerial, "to-serial", noSerial, "roll back migrations applied after this serial (-1 rolls back all)")
+			confirmFlag(flagSet, cfg)
+		},
+		run: func(pgStore *dbmigrat.PostgresStore, migrations dbmigrat.Migrations, repoOrder dbmigrat.RepoOrder, cfg *config, stdout, stderr io.Writer) int {
+			if cfg.toSerial == noSerial {
+				fmt.Fprintln(stderr, errMissingToSerial)
+				return exitUsage
+			}
+			var logsCount int
+			err := confirmed(pgStore, cfg, stdout, func(opts ...dbmigrat.Option) (err error) {
+				logsCount, err = dbmigrat.Rollback(pgStore, migrations, repoOrder.Reversed(), cfg.toSerial, append(cfg.logOptions(), opts...)...)
+				return err
+			})
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				return exitError
+			}
+			fmt.Fprintf(stdout, "[dbmigrat] rolled back %d migrations\n", logsCount)
+			return exitOK
+		},
+	},
+	"env": {
+		flags: func(flagSet *flag.FlagSet, cfg *config) {
+			flagSet.StringVar(&cfg.setEnv, "set", "", "tag database with environment name")
+			flagSet.BoolVar(&cfg.protected, "protected", false, "protect tagged environment (requires -set)")
+			confirmFlag(flagSet, cfg)
+		},
+		run: func(pgStore *dbmigrat.PostgresStore, _ dbmigrat.Migrations, _ dbmigrat.RepoOrder, cfg *config, stdout, stderr io.Writer) int {
+			if cfg.setEnv == "" {
+				if cfg.protected {
+					fmt.Fprintln(stderr, errProtectedWithoutSet)
+					return exitUsage
+				}
+				environment, err := dbmigrat.FetchEnvironment(pgStore)
+				if err != nil {
+					fmt.Fprintln(stderr, err)
+					return exitError
+				}
+				printEnvironment(stdout, environment)
+				return exitOK
+			}
+			environment := dbmigrat.Environment{Name: cfg.setEnv, Protected: cfg.protected}
+			err := confirmed(pgStore, cfg, stdout, func(opts ...dbmigrat.Option) error {
+				return dbmigrat.SetEnvironment(pgStore, environment, opts...)
+			})
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				return exitError
+			}
+			printEnvironment(stdout, &environment)
+			return exitOK
+		},
+	},
+	"status": {
+		needsMigrations: true,
+		flags:           outputFlag,
+		run: func(pgStore *dbmigrat.PostgresStore, migrations dbmigrat.Migrations, repoOrder dbmigrat.RepoOrder, cfg *config, stdout, stderr io.Writer) int {
+			res, err := dbmigrat.Status(pgStore, migrations, repoOrder)
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				return exitError
+			}
+			return printResult(stdout, stderr, cfg, res, func() { printStatus(stdout, res) })
+		},
+	},
+	"verify": {
+		needsMigrations: true,
+		flags:           outputFlag,
+		run: func(pgStore *dbmigrat.PostgresStore, migrations dbmigrat.Migrations, _ dbmigrat.RepoOrder, cfg *config, stdout, stderr io.Writer) int {
+			res, err := dbmigrat.CheckLogTableIntegrity(pgStore, migrations, cfg.logOptions()...)
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				return exitError
+			}
+			exitCode := printResult(stdout, stderr, cfg, res, func() { printIntegrityCheckResult(stdout, res) })
+			if exitCode == exitOK && res.IsCorrupted {
+				return exitIntegrity
+			}
+			return exitCode
+		},
+	},
+	"new": {
+		flags: func(flagSet *flag.FlagSet, cfg *config) {
+			flagSet.BoolVar(&cfg.singleFile, "single", false, "create single file with up and down sections")
+		},
+		runLocal: func(cfg *config, args []string, stdout, stderr io.Writer) int {
+			if len(args) < 2 {
+				fmt.Fprintln(stderr, errNewArgs)
+				return exitUsage
+			}
+			var opts []dbmigrat.NewOption
+			if cfg.singleFile {
+				opts = append(opts, dbmigrat.SingleFile())
+			}
+			if cfg.versioned {
+				opts = append(opts, dbmigrat.VersionedAt(time.Now()))
+			}
+			if cfg.recursive {
+				opts = append(opts, dbmigrat.RecursiveIdx())
+			}
+			created, err := dbmigrat.NewMigration(args[0], strings.Join(args[1:], " "), opts...)
+			for _, filePath := range created {
+				fmt.Fprintf(stdout, "[dbmigrat] created %s\n", filePath)
+			}
+			if errors.Is(err, dbmigrat.ErrEmptyDescription) {
+				fmt.Fprintln(stderr, err)
+				return exitUsage
+			}
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				return exitError
+			}
+			return exitOK
+		},
+	},
+	"plan": {
+		needsMigrations: true,
+		flags: func(flagSet *flag.FlagSet, cfg *config) {
+			flagSet.BoolVar(&cfg.fillGaps, "fill-gaps", false, "plan migrations missing in log below the last applied index")
+			outputFlag(flagSet, cfg)
+		},
+		run: func(pgStore *dbmigrat.PostgresStore, migrations dbmigrat.Migrations, repoOrder dbmigrat.RepoOrder, cfg *config, stdout, stderr io.Writer) int {
+			res, err := dbmigrat.Plan(pgStore, migrations, repoOrder, cfg.migrateOptions()...)
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				return exitError
+			}
+			return printResult(stdout, stderr, cfg, res, func() { printPlan(stdout, res) })
+		},
+	},
+}
+
+func confirmFlag(flagSet *flag.FlagSet, cfg *config) {
+	flagSet.StringVar(&cfg.confirm, "confirm", "", "name of protected environment confirming operation")
+}
+
+// confirmed runs operation confirmed with -confirm flag. When operation is refused by protected environment
+// and the flag is not passed, environment name is read from stdin and operation is run again.
+func confirmed(pgStore *dbmigrat.PostgresStore, cfg *config, stdout io.Writer, operation func(opts ...dbmigrat.Option) error) error {
+	if cfg.confirm != "" {
+		return operation(dbmigrat.WithConfirmation(cfg.confirm))
+	}
+	err := operation()
+	if !errors.Is(err, dbmigrat.ErrProtectedEnvironment) {
+		return err
+	}
+	environment, fetchErr := dbmigrat.FetchEnvironment(pgStore)
+	if fetchErr != nil || environment == nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "database is protected environment %q, type its name to confirm: ", environment.Name)
+	answer, readErr := bufio.NewReader(cfg.stdin).ReadString('\n')
+	if readErr != nil && readErr != io.EOF {
+		return readErr
+	}
+	return operation(dbmigrat.WithConfirmation(strings.TrimSpace(answer)))
+}
+
+func printEnvironment(w io.Writer, environment *dbmigrat.Environment) {
+	switch {
+	case environment == nil:
+		fmt.Fprintln(w, "database is not tagged with environment")
+	case environment.Protected:
+		fmt.Fprintf(w, "environment: %s (protected)\n", environment.Name)
+	default:
+		fmt.Fprintf(w, "environment: %s\n", environment.Name)
+	}
+}
+
+func outputFlag(flagSet *flag.FlagSet, cfg *config) {
+	flagSet.StringVar(&cfg.output, "output", outputText, `output format - "text" or "json"`)
+}
+
+// printResult prints result as JSON or, by default, with printText func. Output flag is validated by config.
+func printResult(stdout, stderr io.Writer, cfg *config, result interface{}, printText func()) int {
+	switch cfg.output {
+	case outputText:
+		printText()
+	case outputJSON:
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		err := encoder.Encode(result)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+	}
+	return exitOK
+}
+
+func printStatus(w io.Writer, res *dbmigrat.StatusResult) {
+	fmt.Fprintf(w, "last migration serial: %d\n", res.LastMigrationSerial)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPO\tAPPLIED\tLAST APPLIED IDX\tPENDING")
+	for _, repoStatus := range res.Repos {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", repoStatus.Repo, repoStatus.Applied, repoStatus.LastAppliedIdx, joinInts(repoStatus.Pending))
+	}
+	tw.Flush()
+}
+
+func printPlan(w io.Writer, res *dbmigrat.PlanResult) {
+	if len(res.Migrations) == 0 {
+		fmt.Fprintln(w, "nothing to apply")
+		return
+	}
+	fmt.Fprintf(w, "migrations to apply with serial %d:\n", res.MigrationSerial)
+	for _, planned := range res.Migrations {
+		fmt.Fprintf(w, "  %s:%d %s\n", planned.Repo, planned.Idx, planned.Description)
+	}
+}
+
+func printIntegrityCheckResult(w io.Writer, res *dbmigrat.IntegrityCheckResult) {
+	if !res.IsCorrupted {
+		fmt.Fprintln(w, "migrations log is consistent with migrations")
+		return
+	}
+	fmt.Fprintln(w, "migrations log is corrupted")
+	var redundantRepos []string
+	for repo := range res.RedundantRepos {
+		redundantRepos = append(redundantRepos, string(repo))
+	}
+	sort.Strings(redundantRepos)
+	for _, repo := range redundantRepos {
+		fmt.Fprintf(w, "  redundant repo: %s\n", repo)
+	}
+	printRepoCounts(w, "redundant migrations", res.RedundantMigrations)
+	printRepoCounts(w, "invalid checksums", res.InvalidChecksums)
+	printRepoCounts(w, "out of order migrations", res.OutOfOrderMigrations)
+	printRepoCounts(w, "reordered migrations", res.ReorderedMigrations)
+	var missingMigrations []string
+	for repo, indexes := range res.MissingMigrations {
+		missingMigrations = append(missingMigrations, fmt.Sprintf("%s (%s)", repo, joinInts(indexes)))
+	}
+	if len(missingMigrations) > 0 {
+		sort.Strings(missingMigrations)
+		fmt.Fprintf(w, "  missing migrations: %s\n", strings.Join(missingMigrations, ", "))
+	}
+	if len(res.MissingSerials) > 0 {
+		fmt.Fprintf(w, "  missing serials: %s\n", joinInts(res.MissingSerials))
+	}
+}
+
+// printRepoCounts prints number of problematic logs of every repo.
+func printRepoCounts(w io.Writer, label string, logs map[dbmigrat.Repo][]dbmigrat.MigrationLog) {
+	repos := make([]string, 0, len(logs))
+	for repo, repoLogs := range logs {
+		if len(repoLogs) > 0 {
+			repos = append(repos, fmt.Sprintf("%s (%d)", repo, len(repoLogs)))
+		}
+	}
+	if len(repos) == 0 {
+		return
+	}
+	sort.Strings(repos)
+	fmt.Fprintf(w, "  %s: %s\n", label, strings.Join(repos, ", "))
+}
+
+func joinInts(ints []int) string {
+	if len(ints) == 0 {
+		return "-"
+	}
+	parts := make([]string, len(ints))
+	for i, v := range ints {
+		parts[i] = fmt.Sprint(v)
+	}
+	return strings.Join(parts, ",")
+}
+
+const noSerial = -2
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+const usage = `usage: dbmigrat <init|up|down|env|status|verify|plan|new> [flags]
+run "dbmigrat <command> -h" for flags of command`
+
+var (
+	errMissingDSN          = errors.New("missing -dsn flag (or DBMIGRAT_DSN environment variable)")
+	errMissingEnv          = errors.New("missing -env flag (or DBMIGRAT_ENV environment variable) selecting environment of project config")
+	errConfigAndRepos      = errors.New("-config flag and -dir or -repo flags are mutually exclusive")
+	errMissingRepos        = errors.New("missing -dir or -repo flag (or DBMIGRAT_DIR, DBMIGRAT_REPOS environment variables)")
+	errDirAndRepos         = errors.New("-dir and -repo flags are mutually exclusive")
+	errInvalidRepo         = errors.New(`-repo flag must be in form "name=directory"`)
+	errMissingToSerial     = errors.New("down requires -to-serial flag (-1 rolls back all migrations)")
+	errInvalidOutput       = errors.New(`-output flag must be "text" or "json"`)
+	errNewArgs             = errors.New("usage: dbmigrat new [-single] [-versioned] [-recursive] <repo directory> <description>")
+	errDuplicatedRepoArg   = errors.New("repo passed more than once")
+	errProtectedWithoutSet = errors.New("-protected flag requires -set flag")
+)
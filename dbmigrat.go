@@ -7,6 +7,8 @@ import (
 	"errors"
 	"fmt"
 	"github.com/hashicorp/go-multierror"
+	"sort"
+	"time"
 )
 
 // Migrate applies migrations to the store in given repoOrder.
@@ -19,59 +21,101 @@ import (
 // repoOrder parameter is an array of repositories names (string). It
 // determines order in which values from migrations map will be applied.
 // eg. if migrations in repo "A" have foreign keys to repo "B" - then repoOrder should be {"B", "A"}
-func Migrate(s store, migrations Migrations, repoOrder RepoOrder) (int, error) {
+//
+// After applying migrations, snapshot of database schema is saved (see DetectDrift).
+//
+// Migrate refuses to run when migrations log contains gaps (missing indexes below
+// the last applied index of repo), unless WithGapFilling option is passed.
+// Logs of migrations filling gaps are marked with MigrationLog.GapFilled.
+// It also refuses to run migration whose Migration.DependsOn lists not applied migration.
+//
+// Hooks passed with WithHooks option are called around the run and every applied migration.
+func Migrate(s store, migrations Migrations, repoOrder RepoOrder, opts ...Option) (int, error) {
+	o := newOptions(opts)
+	run := newHookRun(o, false)
 	err := s.begin()
 	if err != nil {
+		run.onError(err)
 		return 0, err
 	}
 
-	logCount, err := migrate(s, migrations, repoOrder)
+	logCount, err := migrate(s, migrations, repoOrder, o, run)
 	if err != nil {
+		run.onError(err)
 		return 0, multierror.Append(err, s.rollback())
 	}
 
-	return logCount, s.commit()
+	err = s.commit()
+	if err != nil {
+		run.onError(err)
+		return 0, err
+	}
+	return logCount, run.afterRun(s, logCount)
 }
 
-func migrate(s store, migrations Migrations, repoOrder RepoOrder) (int, error) {
-	lastMigrationSerial, err := s.fetchLastMigrationSerial()
+func migrate(s store, migrations Migrations, repoOrder RepoOrder, opts options, run *hookRun) (int, error) {
+	err := run.beforeRun(s)
 	if err != nil {
 		return 0, err
 	}
-	migrationSerial := lastMigrationSerial + 1
-
-	lastMigrationIndexes, err := s.fetchLastMigrationIndexes()
+	state, err := fetchMigrationState(s, migrations, opts)
 	if err != nil {
 		return 0, err
 	}
+	migrationSerial := state.lastMigrationSerial + 1
+	run.setSerial(migrationSerial)
 
 	var insertedLogsCount int
+	appliedNow := map[MigrationRef]bool{}
 	for _, orderedRepo := range repoOrder {
-		repoMigrations, ok := migrations[orderedRepo]
-		if !ok {
-			continue
-		}
-		lastMigrationIdx, ok := lastMigrationIndexes[orderedRepo]
-		if !ok {
-			lastMigrationIdx = -1
-		}
-		if len(repoMigrations) <= lastMigrationIdx+1 {
+		repoMigrations := migrations[orderedRepo]
+		indexesToRun := state.indexesToRun(orderedRepo, repoMigrations)
+		if len(indexesToRun) == 0 {
 			continue
 		}
 
-		var logs []migrationLog
-		for i, migrationToRun := range repoMigrations[lastMigrationIdx+1:] {
-			err = s.exec(migrationToRun.Up)
+		var logs []MigrationLog
+		for _, idx := range indexesToRun {
+			migrationToRun := repoMigrations[idx]
+			for _, dependency := range migrationToRun.DependsOn {
+				if !appliedNow[dependency] && !state.isApplied(dependency) {
+					return 0, errWithRepoIdx{inner: errDependencyNotApplied, repo: orderedRepo, idx: idx}
+				}
+			}
+			if migrationToRun.NoTransaction && len(logs) > 0 {
+				err = s.insertLogs(logs)
+				if err != nil {
+					return 0, err
+				}
+				insertedLogsCount += len(logs)
+				logs = nil
+			}
+			err = execMigration(s, migrationToRun, migrationToRun.Up, run, orderedRepo, idx)
 			if err != nil {
 				return 0, err
 			}
-			logs = append(logs, migrationLog{
-				Idx:             lastMigrationIdx + 1 + i,
+			logs = append(logs, MigrationLog{
+				Idx:             idx,
 				Repo:            orderedRepo,
 				MigrationSerial: migrationSerial,
 				Checksum:        sha1Checksum(migrationToRun.Up),
 				Description:     migrationToRun.Description,
+				Version:         migrationToRun.Version,
+				GapFilled:       state.isMissing(MigrationRef{Repo: orderedRepo, Idx: idx}),
 			})
+			appliedNow[MigrationRef{Repo: orderedRepo, Idx: idx}] = true
+			if migrationToRun.NoTransaction {
+				err = commitLogs(s, s.insertLogs, logs)
+				if err != nil {
+					return 0, err
+				}
+				insertedLogsCount += len(logs)
+				logs = nil
+			}
+		}
+		if len(logs) == 0 {
+			// Logs of repo ending with NoTransaction migration have been committed already.
+			continue
 		}
 		err = s.insertLogs(logs)
 		if err != nil {
@@ -80,33 +124,191 @@ func migrate(s store, migrations Migrations, repoOrder RepoOrder) (int, error) {
 		insertedLogsCount += len(logs)
 	}
 
+	if insertedLogsCount > 0 {
+		snapshot, err := s.fetchSchemaSnapshot()
+		if err != nil {
+			return 0, err
+		}
+		err = s.insertSchemaSnapshot(migrationSerial, snapshot)
+		if err != nil {
+			return 0, err
+		}
+	}
+
 	return insertedLogsCount, nil
 }
 
+// migrationState describes migrations log before running Migrate.
+type migrationState struct {
+	lastMigrationSerial     int
+	lastMigrationIndexes    map[Repo]int
+	missingMigrationIndexes map[Repo][]int
+}
+
+// fetchMigrationState fetches state of migrations log and checks if Migrate might run on it.
+func fetchMigrationState(s store, migrations Migrations, opts options) (*migrationState, error) {
+	lastMigrationSerial, err := s.fetchLastMigrationSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	lastMigrationIndexes, err := s.fetchLastMigrationIndexes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkVersions(s, migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	missingMigrationIndexes, err := s.fetchMissingMigrationIndexes()
+	if err != nil {
+		return nil, err
+	}
+	if len(missingMigrationIndexes) > 0 && !opts.fillGaps {
+		repos := make([]Repo, 0, len(missingMigrationIndexes))
+		for repo := range missingMigrationIndexes {
+			repos = append(repos, repo)
+		}
+		sortRepos(repos)
+		return nil, errWithRepoIdx{inner: errLogContainsGaps, repo: repos[0], idx: missingMigrationIndexes[repos[0]][0]}
+	}
+
+	return &migrationState{
+		lastMigrationSerial:     lastMigrationSerial,
+		lastMigrationIndexes:    lastMigrationIndexes,
+		missingMigrationIndexes: missingMigrationIndexes,
+	}, nil
+}
+
+// indexesToRun returns indexes of repo's migrations in order in which Migrate applies them.
+// Migrations missing in log (gaps) go first, then migrations newer than the last applied one.
+func (ms *migrationState) indexesToRun(repo Repo, repoMigrations []Migration) []int {
+	lastMigrationIdx, ok := ms.lastMigrationIndexes[repo]
+	if !ok {
+		lastMigrationIdx = -1
+	}
+
+	var indexesToRun []int
+	for _, missingIdx := range ms.missingMigrationIndexes[repo] {
+		if missingIdx < len(repoMigrations) {
+			indexesToRun = append(indexesToRun, missingIdx)
+		}
+	}
+	for idx := lastMigrationIdx + 1; idx < len(repoMigrations); idx++ {
+		indexesToRun = append(indexesToRun, idx)
+	}
+	return indexesToRun
+}
+
+// isApplied tells if migration referenced by ref is saved in migrations log.
+func (ms *migrationState) isApplied(ref MigrationRef) bool {
+	lastMigrationIdx, ok := ms.lastMigrationIndexes[ref.Repo]
+	if !ok || ref.Idx > lastMigrationIdx {
+		return false
+	}
+	return !ms.isMissing(ref)
+}
+
+// isMissing tells if migration referenced by ref is gap in migrations log.
+func (ms *migrationState) isMissing(ref MigrationRef) bool {
+	for _, missingIdx := range ms.missingMigrationIndexes[ref.Repo] {
+		if missingIdx == ref.Idx {
+			return true
+		}
+	}
+	return false
+}
+
+// checkVersions returns error when version saved in migrations log differs
+// from version of migration with the same index. Logs without version are not checked.
+func checkVersions(s store, migrations Migrations) error {
+	migrationLogs, err := s.fetchAllMigrationLogs()
+	if err != nil {
+		return err
+	}
+	for _, log := range migrationLogs {
+		repoMigrations, ok := migrations[log.Repo]
+		if !ok || log.Version == "" || log.Idx >= len(repoMigrations) {
+			continue
+		}
+		if repoMigrations[log.Idx].Version != log.Version {
+			return errWithRepoIdx{inner: errVersionMismatch, repo: log.Repo, idx: log.Idx}
+		}
+	}
+	return nil
+}
+
 // Rollback rolls back migrations applied by Migrate func
 //
 // repoOrder should be reversed one passed to Migrate func
 //
 // migration serial represents applied migrations (from different repos) in single run of Migrate func.
 // When toMigrationSerial == -1, then all applied migrations will be rolled back.
-func Rollback(s store, migrations Migrations, repoOrder RepoOrder, toMigrationSerial int) (int, error) {
+//
+// Rollback refuses to run (returns ErrIrreversibleMigration) when any of migrations
+// to roll back is marked as Irreversible. On database tagged with protected Environment
+// it requires WithConfirmation option. Hooks passed with WithHooks option are called
+// around the run and every rolled back migration.
+func Rollback(s store, migrations Migrations, repoOrder RepoOrder, toMigrationSerial int, opts ...Option) (int, error) {
+	o := newOptions(opts)
+	run := newHookRun(o, true)
 	err := s.begin()
 	if err != nil {
+		run.onError(err)
 		return 0, err
 	}
-	deletedLogs, err := rollback(s, migrations, repoOrder, toMigrationSerial)
+	deletedLogs, err := rollback(s, migrations, repoOrder, toMigrationSerial, o, run)
 	if err != nil {
+		run.onError(err)
 		return 0, multierror.Append(err, s.rollback())
 	}
-	return deletedLogs, s.commit()
+	err = s.commit()
+	if err != nil {
+		run.onError(err)
+		return 0, err
+	}
+	return deletedLogs, run.afterRun(s, deletedLogs)
 }
 
-func rollback(s store, migrations Migrations, repoOrder RepoOrder, toMigrationSerial int) (int, error) {
+func rollback(s store, migrations Migrations, repoOrder RepoOrder, toMigrationSerial int, opts options, run *hookRun) (int, error) {
+	err := checkProtection(s, opts)
+	if err != nil {
+		return 0, err
+	}
+	err = run.beforeRun(s)
+	if err != nil {
+		return 0, err
+	}
+	run.setToSerial(toMigrationSerial)
 	repoToReverseIndexes, err := s.fetchReverseMigrationIndexesAfterSerial(toMigrationSerial)
 	if err != nil {
 		return 0, err
 	}
-	var logsToDelete []migrationLog
+	migrationLogs, err := s.fetchAllMigrationLogs()
+	if err != nil {
+		return 0, err
+	}
+	serials := map[MigrationRef]int{}
+	for _, log := range migrationLogs {
+		serials[MigrationRef{Repo: log.Repo, Idx: log.Idx}] = log.MigrationSerial
+	}
+	for _, orderedRepo := range repoOrder {
+		for _, migrationIdx := range repoToReverseIndexes[orderedRepo] {
+			if migrationIdx < len(migrations[orderedRepo]) && migrations[orderedRepo][migrationIdx].Irreversible {
+				return 0, errWithRepoIdx{
+					inner:       ErrIrreversibleMigration,
+					repo:        orderedRepo,
+					idx:         migrationIdx,
+					description: migrations[orderedRepo][migrationIdx].Description,
+				}
+			}
+		}
+	}
+
+	var deletedLogsCount int
+	var logsToDelete []MigrationLog
 	for _, orderedRepo := range repoOrder {
 		reverseIndexes, ok := repoToReverseIndexes[orderedRepo]
 		if !ok {
@@ -116,19 +318,91 @@ func rollback(s store, migrations Migrations, repoOrder RepoOrder, toMigrationSe
 			if len(migrations[orderedRepo]) <= migrationIdx {
 				return 0, errMigrationsOutSync
 			}
-			err := s.exec(migrations[orderedRepo][migrationIdx].Down)
+			migrationToRollback := migrations[orderedRepo][migrationIdx]
+			if migrationToRollback.NoTransaction && len(logsToDelete) > 0 {
+				err = s.deleteLogs(logsToDelete)
+				if err != nil {
+					return 0, err
+				}
+				deletedLogsCount += len(logsToDelete)
+				logsToDelete = nil
+			}
+			run.setRolledBackSerial(serials[MigrationRef{Repo: orderedRepo, Idx: migrationIdx}])
+			err := execMigration(s, migrationToRollback, migrationToRollback.Down, run, orderedRepo, migrationIdx)
 			if err != nil {
 				return 0, err
 			}
-			logsToDelete = append(logsToDelete, migrationLog{Idx: migrationIdx, Repo: orderedRepo})
+			logsToDelete = append(logsToDelete, MigrationLog{Idx: migrationIdx, Repo: orderedRepo})
+			if migrationToRollback.NoTransaction {
+				err = commitLogs(s, s.deleteLogs, logsToDelete)
+				if err != nil {
+					return 0, err
+				}
+				deletedLogsCount += len(logsToDelete)
+				logsToDelete = nil
+			}
 		}
 	}
 	err = s.deleteLogs(logsToDelete)
 	if err != nil {
 		return 0, err
 	}
+	err = s.deleteSchemaSnapshotsAfterSerial(toMigrationSerial)
+	if err != nil {
+		return 0, err
+	}
 
-	return len(logsToDelete), nil
+	deletedLogsCount += len(logsToDelete)
+	return deletedLogsCount, nil
+}
+
+// execMigration runs query within current transaction, unless migration opts out of transactions.
+// In such case, current transaction is committed before running query and new one is began afterwards.
+// Query is wrapped with migration hooks of the run.
+func execMigration(s store, migration Migration, query string, run *hookRun, repo Repo, idx int) error {
+	if !migration.NoTransaction {
+		return run.migration(s, repo, idx, migration, query, func() error {
+			return execTimed(s, migration, query)
+		})
+	}
+	err := s.commit()
+	if err != nil {
+		return err
+	}
+	execErr := run.migration(s, repo, idx, migration, query, func() error {
+		return execTimed(s, migration, query)
+	})
+	err = s.begin()
+	if execErr != nil {
+		return execErr
+	}
+	return err
+}
+
+// commitLogs saves (or deletes on Rollback) logs of migrations run so far and commits them,
+// so log of NoTransaction migration is kept even when run fails afterwards.
+func commitLogs(s store, save func(logs []MigrationLog) error, logs []MigrationLog) error {
+	err := save(logs)
+	if err != nil {
+		return err
+	}
+	err = s.commit()
+	if err != nil {
+		return err
+	}
+	return s.begin()
+}
+
+// execTimed runs query of migration with its timeouts (or defaults of the store).
+func execTimed(s store, migration Migration, query string) error {
+	limits := s.defaultTimeouts()
+	if migration.StatementTimeout > 0 {
+		limits.statement = migration.StatementTimeout
+	}
+	if migration.LockTimeout > 0 {
+		limits.lock = migration.LockTimeout
+	}
+	return s.exec(query, limits)
 }
 
 type Migrations map[Repo][]Migration
@@ -137,18 +411,69 @@ type Migration struct {
 	Description string
 	Up          string
 	Down        string
+	// Version is optional sortable version of migration (eg. timestamp) saved in migrations log.
+	// Migrate refuses to run when version of applied migration differs from saved one,
+	// which means that migrations have been reordered (eg. on merge).
+	Version string
+	// Irreversible marks migration which can not be rolled back (eg. dropping column with data).
+	// Down is not required for such migration, Rollback refuses to roll it back.
+	Irreversible bool
+	// NoTransaction makes migration run outside of transaction (eg. for "create index concurrently").
+	// Migrations applied before such migration are committed, so they are not reverted when it fails.
+	// Its log is committed right after it succeeds, so it is kept when any later migration of the run fails.
+	NoTransaction bool
+	// DependsOn lists migrations (possibly from other repos) which must be applied before this one.
+	// Migrate refuses to run migration with unapplied dependency.
+	DependsOn []MigrationRef
+	// StatementTimeout limits duration of every statement of migration. LockTimeout limits time
+	// spent on waiting for locks. Zero means default of PostgresStore (PostgresStore.StatementTimeout,
+	// PostgresStore.LockTimeout). They are applied with "set local" (or to session of NoTransaction migration)
+	// and previous values are restored after migration. Timeouts are rounded up to whole milliseconds.
+	StatementTimeout time.Duration
+	LockTimeout      time.Duration
+	// Tags and Author are informational metadata, they are not used by dbmigrat.
+	Tags   []string
+	Author string
+}
+
+// MigrationRef identifies migration by its repo and index.
+type MigrationRef struct {
+	Repo Repo
+	Idx  int
 }
 
 type RepoOrder []Repo
 
+// Reversed returns copy of repoOrder in reversed order.
+// It allows for passing to Rollback func the same RepoOrder as to Migrate func.
+func (ro RepoOrder) Reversed() RepoOrder {
+	reversed := make(RepoOrder, len(ro))
+	for i, repo := range ro {
+		reversed[len(ro)-1-i] = repo
+	}
+	return reversed
+}
+
 // Repo is set of migrations. It allows for storing migrations in several locations.
 // Example:
 // e-commerce app might store authentication related migrations in repo "auth"
 // while billing migrations in repo "billing".
 type Repo string
 
+func sortRepos(repos []Repo) {
+	sort.Slice(repos, func(i, j int) bool { return repos[i] < repos[j] })
+}
+
 func sha1Checksum(data string) string {
 	return fmt.Sprintf("%x", sha1.Sum([]byte(data)))
 }
 
-var errMigrationsOutSync = errors.New("migrations passed to Rollback func are not in sync with migrations log. You might want to run CheckLogTableIntegrity func")
+// ErrIrreversibleMigration is returned by Rollback func when migration to roll back is marked as Irreversible.
+var ErrIrreversibleMigration = errors.New("migration is irreversible and can not be rolled back")
+
+var (
+	errMigrationsOutSync    = errors.New("migrations passed to Rollback func are not in sync with migrations log. You might want to run CheckLogTableIntegrity func")
+	errLogContainsGaps      = errors.New("migrations log contains gaps. You might want to run CheckLogTableIntegrity func or pass WithGapFilling option")
+	errVersionMismatch      = errors.New("version of applied migration differs from version of passed migration with the same index (migrations reordered?)")
+	errDependencyNotApplied = errors.New("migration depends on migration which is not applied. You might want to change repoOrder")
+)
@@ -2,6 +2,7 @@ package dbmigrat
 
 import (
 	"errors"
+	"fmt"
 	"github.com/hashicorp/go-multierror"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
@@ -9,6 +10,7 @@ import (
 	"log"
 	"os"
 	"testing"
+	"time"
 )
 
 var th *testHelper
@@ -47,8 +49,12 @@ func TestMigrateError(t *testing.T) {
 		{name: "tx begin fail", storeMock: errorStoreMock{wrapped: th.pgStore, errBegin: true}, errExpected: exampleErr},
 		{name: "fetchLastMigrationSerial fail", storeMock: errorStoreMock{wrapped: th.pgStore, errFetchLastMigrationSerial: true}, errExpected: exampleMultiErr},
 		{name: "fetchLastMigrationIndexes fail", storeMock: errorStoreMock{wrapped: th.pgStore, errFetchLastMigrationIndexes: true}, errExpected: exampleMultiErr},
+		{name: "fetchAllMigrationLogs fail", storeMock: errorStoreMock{wrapped: th.pgStore, errFetchAllMigrationLogs: true}, errExpected: exampleMultiErr},
+		{name: "fetchMissingMigrationIndexes fail", storeMock: errorStoreMock{wrapped: th.pgStore, errFetchMissingMigrationIndexes: true}, errExpected: exampleMultiErr},
 		{name: "exec fail", storeMock: errorStoreMock{wrapped: th.pgStore, errExec: true}, errExpected: exampleMultiErr},
 		{name: "insertLogs fail", storeMock: errorStoreMock{wrapped: th.pgStore, errInsertLogs: true}, errExpected: exampleMultiErr},
+		{name: "fetchSchemaSnapshot fail", storeMock: errorStoreMock{wrapped: th.pgStore, errFetchSchemaSnapshot: true}, errExpected: exampleMultiErr},
+		{name: "insertSchemaSnapshot fail", storeMock: errorStoreMock{wrapped: th.pgStore, errInsertSchemaSnapshot: true}, errExpected: exampleMultiErr},
 	}
 
 	for _, testCase := range caseTable {
@@ -60,6 +66,248 @@ func TestMigrateError(t *testing.T) {
 	}
 }
 
+func TestMigrateNoTransaction(t *testing.T) {
+	assert.NoError(t, th.resetDB())
+	assert.NoError(t, th.pgStore.CreateLogTable())
+	migrations := Migrations{
+		"auth": append(th.migrations1["auth"],
+			Migration{
+				Up:            `create index concurrently users_username_idx on users (username)`,
+				Down:          `drop index concurrently users_username_idx`,
+				Description:   "add username index",
+				NoTransaction: true,
+			},
+			Migration{Up: `alter table users add column email varchar(255)`, Down: `alter table users drop column email`, Description: "add email column"},
+		),
+	}
+
+	logCount, err := Migrate(th.pgStore, migrations, RepoOrder{"auth"})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, logCount)
+
+	logCount, err = Rollback(th.pgStore, migrations, RepoOrder{"auth"}, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, logCount)
+
+	t.Run("migrations applied before failed one remain committed", func(t *testing.T) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+		failing := migrations["auth"][2]
+		failing.Up = `create index concurrently users_username_idx on non_existing_table (username)`
+
+		logCount, err := Migrate(th.pgStore, Migrations{"auth": {migrations["auth"][0], migrations["auth"][1], failing}}, RepoOrder{"auth"})
+		assert.Error(t, err)
+		assert.Equal(t, 0, logCount)
+
+		lastIndexes, err := th.pgStore.fetchLastMigrationIndexes()
+		assert.NoError(t, err)
+		assert.Equal(t, map[Repo]int{"auth": 1}, lastIndexes)
+	})
+
+	t.Run("log of NoTransaction migration remains when following migration fails", func(t *testing.T) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+		failing := migrations["auth"][3]
+		failing.Up = `alter table non_existing_table add column email varchar(255)`
+
+		logCount, err := Migrate(th.pgStore, Migrations{"auth": {migrations["auth"][0], migrations["auth"][1], migrations["auth"][2], failing}}, RepoOrder{"auth"})
+		assert.Error(t, err)
+		assert.Equal(t, 0, logCount)
+
+		lastIndexes, err := th.pgStore.fetchLastMigrationIndexes()
+		assert.NoError(t, err)
+		assert.Equal(t, map[Repo]int{"auth": 2}, lastIndexes)
+	})
+
+	t.Run("NoTransaction migration is the last one of repo", func(t *testing.T) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+		lastNoTransaction := Migrations{"auth": {migrations["auth"][0], migrations["auth"][1], migrations["auth"][2]}, "billing": th.migrations1["billing"]}
+
+		logCount, err := Migrate(th.pgStore, lastNoTransaction, RepoOrder{"auth", "billing"})
+		assert.NoError(t, err)
+		assert.Equal(t, 4, logCount)
+
+		lastIndexes, err := th.pgStore.fetchLastMigrationIndexes()
+		assert.NoError(t, err)
+		assert.Equal(t, map[Repo]int{"auth": 2, "billing": 0}, lastIndexes)
+
+		logCount, err = Rollback(th.pgStore, lastNoTransaction, RepoOrder{"billing", "auth"}, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, logCount)
+	})
+
+	t.Run("deleted log of NoTransaction migration remains deleted when following rollback fails", func(t *testing.T) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+		_, err := Migrate(th.pgStore, migrations, RepoOrder{"auth"})
+		assert.NoError(t, err)
+		failing := Migrations{"auth": {migrations["auth"][0], migrations["auth"][1], migrations["auth"][2], migrations["auth"][3]}}
+		failing["auth"][1].Down = `alter table non_existing_table drop column username`
+
+		logCount, err := Rollback(th.pgStore, failing, RepoOrder{"auth"}, -1)
+		assert.Error(t, err)
+		assert.Equal(t, 0, logCount)
+
+		lastIndexes, err := th.pgStore.fetchLastMigrationIndexes()
+		assert.NoError(t, err)
+		assert.Equal(t, map[Repo]int{"auth": 1}, lastIndexes)
+	})
+}
+
+func TestMigrateVersions(t *testing.T) {
+	assert.NoError(t, th.resetDB())
+	assert.NoError(t, th.pgStore.CreateLogTable())
+	createUsers := Migration{Up: `create table users (id serial primary key)`, Down: `drop table users`, Description: "create user table", Version: "20211018143000"}
+	addUsername := Migration{Up: `alter table users add column username varchar(32)`, Down: `alter table users drop column username`, Description: "add username column", Version: "20211020100000"}
+	addEmail := Migration{Up: `alter table users add column email varchar(255)`, Down: `alter table users drop column email`, Description: "add email column", Version: "20211019120000"}
+
+	logCount, err := Migrate(th.pgStore, Migrations{"auth": {createUsers, addUsername}}, RepoOrder{"auth"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, logCount)
+
+	var versions []string
+	assert.NoError(t, th.db.Select(&versions, `select version from dbmigrat_log order by idx`))
+	assert.Equal(t, []string{"20211018143000", "20211020100000"}, versions)
+
+	// # Migration with lower version merged after applying newer one
+	reordered := Migrations{"auth": {createUsers, addEmail, addUsername}}
+	logCount, err = Migrate(th.pgStore, reordered, RepoOrder{"auth"})
+	assert.EqualError(t, err, multierror.Append(errWithRepoIdx{inner: errVersionMismatch, repo: "auth", idx: 1}).Error())
+	assert.Equal(t, 0, logCount)
+
+	checkRes, err := CheckLogTableIntegrity(th.pgStore, reordered)
+	assert.NoError(t, err)
+	assert.True(t, checkRes.IsCorrupted)
+	assert.Len(t, checkRes.ReorderedMigrations["auth"], 1)
+	assert.Equal(t, "20211020100000", checkRes.ReorderedMigrations["auth"][0].Version)
+}
+
+func TestMigrateGaps(t *testing.T) {
+	before := func(t *testing.T) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+		_, err := Migrate(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"})
+		assert.NoError(t, err)
+		// # Simulate manual removal of log entry and reverting of migration
+		_, err = th.db.Exec(`delete from dbmigrat_log where repo = 'auth' and idx = 0`)
+		assert.NoError(t, err)
+		_, err = th.db.Exec(`drop table users cascade`)
+		assert.NoError(t, err)
+	}
+
+	t.Run("refuses to run on log with gaps", func(t *testing.T) {
+		before(t)
+
+		logCount, err := Migrate(th.pgStore, th.migrations2, RepoOrder{"auth", "billing", "delivery"})
+		assert.EqualError(t, err, multierror.Append(errWithRepoIdx{inner: errLogContainsGaps, repo: "auth", idx: 0}).Error())
+		assert.Equal(t, 0, logCount)
+	})
+
+	t.Run("fills gaps when option passed", func(t *testing.T) {
+		before(t)
+
+		logCount, err := Migrate(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"}, WithGapFilling())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, logCount)
+
+		missingIndexes, err := th.pgStore.fetchMissingMigrationIndexes()
+		assert.NoError(t, err)
+		assert.Empty(t, missingIndexes)
+
+		var gapFilled []bool
+		assert.NoError(t, th.db.Select(&gapFilled, `select gap_filled from dbmigrat_log where repo = 'auth' order by idx`))
+		assert.Equal(t, []bool{true, false}, gapFilled)
+
+		checkRes, err := CheckLogTableIntegrity(th.pgStore, th.migrations1)
+		assert.NoError(t, err)
+		assert.False(t, checkRes.IsCorrupted)
+		assert.Empty(t, checkRes.OutOfOrderMigrations)
+	})
+}
+
+func TestMigrateDependencies(t *testing.T) {
+	assert.NoError(t, th.resetDB())
+	assert.NoError(t, th.pgStore.CreateLogTable())
+	createOrders := Migration{
+		Up:          `create table orders (id serial primary key, user_id integer references users (id))`,
+		Down:        `drop table orders`,
+		Description: "create orders table",
+		DependsOn:   []MigrationRef{{Repo: "auth", Idx: 0}},
+	}
+	migrations := Migrations{"auth": th.migrations1["auth"], "billing": {createOrders}}
+
+	logCount, err := Migrate(th.pgStore, migrations, RepoOrder{"billing", "auth"})
+	assert.EqualError(t, err, multierror.Append(errWithRepoIdx{inner: errDependencyNotApplied, repo: "billing", idx: 0}).Error())
+	assert.Equal(t, 0, logCount)
+
+	logCount, err = Migrate(th.pgStore, migrations, RepoOrder{"auth", "billing"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, logCount)
+}
+
+func TestMigrateTimeouts(t *testing.T) {
+	assert.NoError(t, th.resetDB())
+	assert.NoError(t, th.pgStore.CreateLogTable())
+	pgStore := &PostgresStore{DB: th.db, StatementTimeout: 10 * time.Second, LockTimeout: 2 * time.Second}
+	const recordTimeouts = `insert into timeouts select %d, current_setting('statement_timeout'), current_setting('lock_timeout')`
+	migrations := Migrations{"auth": {
+		{Up: `create table timeouts (idx integer, statement_timeout text, lock_timeout text);` + fmt.Sprintf(recordTimeouts, 0), Down: `drop table timeouts`},
+		{Up: fmt.Sprintf(recordTimeouts, 1), Down: `delete from timeouts where idx = 1`, LockTimeout: 5 * time.Second},
+		{Up: fmt.Sprintf(recordTimeouts, 2), Down: `delete from timeouts where idx = 2`, StatementTimeout: time.Minute},
+	}}
+
+	_, err := Migrate(pgStore, migrations, RepoOrder{"auth"})
+	assert.NoError(t, err)
+	var recorded []struct {
+		Idx              int    `db:"idx"`
+		StatementTimeout string `db:"statement_timeout"`
+		LockTimeout      string `db:"lock_timeout"`
+	}
+	assert.NoError(t, th.db.Select(&recorded, `select * from timeouts order by idx`))
+	assert.Len(t, recorded, 3)
+	for idx, expected := range [][2]string{{"10s", "2s"}, {"10s", "5s"}, {"1min", "2s"}} {
+		assert.Equal(t, expected[0], recorded[idx].StatementTimeout, idx)
+		assert.Equal(t, expected[1], recorded[idx].LockTimeout, idx)
+	}
+
+	t.Run("statement exceeding timeout fails", func(t *testing.T) {
+		slow := Migrations{"auth": append(migrations["auth"], Migration{Up: `select pg_sleep(1)`, Down: `select 1`, StatementTimeout: 10 * time.Millisecond})}
+		_, err := Migrate(pgStore, slow, RepoOrder{"auth"})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "statement timeout")
+	})
+
+	t.Run("timeouts of NoTransaction migration are applied to its session", func(t *testing.T) {
+		noTransaction := Migrations{"auth": append(migrations["auth"], Migration{Up: fmt.Sprintf(recordTimeouts, 3), Down: `delete from timeouts where idx = 3`, NoTransaction: true, LockTimeout: 3 * time.Second})}
+		_, err := Migrate(pgStore, noTransaction, RepoOrder{"auth"})
+		assert.NoError(t, err)
+		var lockTimeout string
+		assert.NoError(t, th.db.Get(&lockTimeout, `select lock_timeout from timeouts where idx = 3`))
+		assert.Equal(t, "3s", lockTimeout)
+	})
+
+	t.Run("previous values of settings are restored", func(t *testing.T) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+		hooks := Hooks{BeforeRun: func(event RunEvent) error {
+			_, err := event.Tx.Exec(`set local lock_timeout = '7s'`)
+			return err
+		}}
+		_, err := Migrate(&PostgresStore{DB: th.db}, migrations, RepoOrder{"auth"}, WithHooks(hooks))
+		assert.NoError(t, err)
+		var lockTimeouts []string
+		assert.NoError(t, th.db.Select(&lockTimeouts, `select lock_timeout from timeouts order by idx`))
+		assert.Equal(t, []string{"7s", "5s", "7s"}, lockTimeouts)
+	})
+}
+
+func TestTimeoutsSettings(t *testing.T) {
+	assert.Empty(t, timeouts{}.settings())
+	assert.Equal(t, []setting{{name: "statement_timeout", value: "1"}, {name: "lock_timeout", value: "1500"}}, timeouts{statement: time.Microsecond, lock: 1500 * time.Millisecond}.settings())
+	assert.Equal(t, []setting{{name: "lock_timeout", value: "2"}}, timeouts{lock: time.Millisecond + time.Nanosecond}.settings())
+}
+
 func TestRollback(t *testing.T) {
 	before := func(t *testing.T) {
 		assert.NoError(t, th.resetDB())
@@ -84,8 +332,10 @@ func TestRollback(t *testing.T) {
 		caseTable := caseTable{
 			{name: "tx begin fail", storeMock: errorStoreMock{wrapped: th.pgStore, errBegin: true}, errExpected: exampleErr},
 			{name: "fetchReverseMigrationIndexesAfterSerial fail", storeMock: errorStoreMock{wrapped: th.pgStore, errFetchReverseMigrationIndexesAfterSerial: true}, errExpected: exampleMultiErr},
+			{name: "fetchAllMigrationLogs fail", storeMock: errorStoreMock{wrapped: th.pgStore, errFetchAllMigrationLogs: true}, errExpected: exampleMultiErr},
 			{name: "exec fail", storeMock: errorStoreMock{wrapped: th.pgStore, errExec: true}, errExpected: exampleMultiErr},
 			{name: "deleteLogs fail", storeMock: errorStoreMock{wrapped: th.pgStore, errDeleteLogs: true}, errExpected: exampleMultiErr},
+			{name: "deleteSchemaSnapshotsAfterSerial fail", storeMock: errorStoreMock{wrapped: th.pgStore, errDeleteSchemaSnapshotsAfterSerial: true}, errExpected: exampleMultiErr},
 		}
 
 		for _, testCase := range caseTable {
@@ -96,6 +346,25 @@ func TestRollback(t *testing.T) {
 			})
 		}
 
+		t.Run("irreversible migration", func(t *testing.T) {
+			irreversible := Migrations{
+				"auth":    th.migrations2["auth"],
+				"billing": {th.migrations2["billing"][0], th.migrations2["billing"][1]},
+			}
+			irreversible["billing"][1].Irreversible = true
+			irreversible["billing"][1].Down = ""
+
+			logCount, err := Rollback(th.pgStore, irreversible, RepoOrder{"delivery", "billing", "auth"}, 0)
+			assert.EqualError(t, err, multierror.Append(errWithRepoIdx{inner: ErrIrreversibleMigration, repo: "billing", idx: 1, description: "add value gross column"}).Error())
+			assert.True(t, errors.Is(err, ErrIrreversibleMigration))
+			assert.Equal(t, 0, logCount)
+
+			// # Rolling back to serial after irreversible migration is allowed
+			logCount, err = Rollback(th.pgStore, irreversible, RepoOrder{"delivery", "billing", "auth"}, 1)
+			assert.NoError(t, err)
+			assert.Equal(t, 0, logCount)
+		})
+
 		t.Run("too less migrations provided", func(t *testing.T) {
 			logCount, err := Rollback(th.pgStore, th.migrations1, RepoOrder{"delivery", "billing", "auth"}, 0)
 			assert.EqualError(t, err, multierror.Append(errMigrationsOutSync).Error())
@@ -104,6 +373,13 @@ func TestRollback(t *testing.T) {
 	})
 }
 
+func TestRepoOrderReversed(t *testing.T) {
+	repoOrder := RepoOrder{"auth", "billing", "delivery"}
+	assert.Equal(t, RepoOrder{"delivery", "billing", "auth"}, repoOrder.Reversed())
+	assert.Equal(t, RepoOrder{"auth", "billing", "delivery"}, repoOrder)
+	assert.Equal(t, RepoOrder{}, RepoOrder(nil).Reversed())
+}
+
 type caseTable []struct {
 	name        string
 	storeMock   store
@@ -116,7 +392,7 @@ func (s errorStoreMock) CreateLogTable() error {
 	}
 	return s.wrapped.CreateLogTable()
 }
-func (s errorStoreMock) fetchAllMigrationLogs() ([]migrationLog, error) {
+func (s errorStoreMock) fetchAllMigrationLogs() ([]MigrationLog, error) {
 	if s.errFetchAllMigrationLogs {
 		return nil, exampleErr
 	}
@@ -128,7 +404,7 @@ func (s errorStoreMock) fetchLastMigrationSerial() (int, error) {
 	}
 	return s.wrapped.fetchLastMigrationSerial()
 }
-func (s errorStoreMock) insertLogs(logs []migrationLog) error {
+func (s errorStoreMock) insertLogs(logs []MigrationLog) error {
 	if s.errInsertLogs {
 		return exampleErr
 	}
@@ -140,18 +416,78 @@ func (s errorStoreMock) fetchLastMigrationIndexes() (map[Repo]int, error) {
 	}
 	return s.wrapped.fetchLastMigrationIndexes()
 }
+func (s errorStoreMock) fetchMissingMigrationIndexes() (map[Repo][]int, error) {
+	if s.errFetchMissingMigrationIndexes {
+		return nil, exampleErr
+	}
+	return s.wrapped.fetchMissingMigrationIndexes()
+}
 func (s errorStoreMock) fetchReverseMigrationIndexesAfterSerial(serial int) (map[Repo][]int, error) {
 	if s.errFetchReverseMigrationIndexesAfterSerial {
 		return nil, exampleErr
 	}
 	return s.wrapped.fetchReverseMigrationIndexesAfterSerial(serial)
 }
-func (s errorStoreMock) deleteLogs(logs []migrationLog) error {
+func (s errorStoreMock) deleteLogs(logs []MigrationLog) error {
 	if s.errDeleteLogs {
 		return exampleErr
 	}
 	return s.wrapped.deleteLogs(logs)
 }
+func (s errorStoreMock) updateLogs(logs []MigrationLog) error {
+	if s.errUpdateLogs {
+		return exampleErr
+	}
+	return s.wrapped.updateLogs(logs)
+}
+func (s errorStoreMock) insertRepairLogs(logs []repairLog) error {
+	if s.errInsertRepairLogs {
+		return exampleErr
+	}
+	return s.wrapped.insertRepairLogs(logs)
+}
+func (s errorStoreMock) fetchSchemaSnapshot() (SchemaSnapshot, error) {
+	if s.errFetchSchemaSnapshot {
+		return nil, exampleErr
+	}
+	return s.wrapped.fetchSchemaSnapshot()
+}
+func (s errorStoreMock) insertSchemaSnapshot(serial int, snapshot SchemaSnapshot) error {
+	if s.errInsertSchemaSnapshot {
+		return exampleErr
+	}
+	return s.wrapped.insertSchemaSnapshot(serial, snapshot)
+}
+func (s errorStoreMock) fetchLastSchemaSnapshot() (int, SchemaSnapshot, error) {
+	if s.errFetchLastSchemaSnapshot {
+		return -1, nil, exampleErr
+	}
+	return s.wrapped.fetchLastSchemaSnapshot()
+}
+func (s errorStoreMock) deleteSchemaSnapshotsAfterSerial(serial int) error {
+	if s.errDeleteSchemaSnapshotsAfterSerial {
+		return exampleErr
+	}
+	return s.wrapped.deleteSchemaSnapshotsAfterSerial(serial)
+}
+func (s errorStoreMock) fetchEnvironment() (*Environment, error) {
+	if s.errFetchEnvironment {
+		return nil, exampleErr
+	}
+	return s.wrapped.fetchEnvironment()
+}
+func (s errorStoreMock) saveEnvironment(environment Environment) error {
+	if s.errSaveEnvironment {
+		return exampleErr
+	}
+	return s.wrapped.saveEnvironment(environment)
+}
+func (s errorStoreMock) defaultTimeouts() timeouts {
+	return s.wrapped.defaultTimeouts()
+}
+func (s errorStoreMock) currentTx() Tx {
+	return s.wrapped.currentTx()
+}
 func (s errorStoreMock) begin() error {
 	if s.errBegin {
 		return exampleErr
@@ -170,11 +506,11 @@ func (s errorStoreMock) commit() error {
 	}
 	return s.wrapped.commit()
 }
-func (s errorStoreMock) exec(query string) error {
+func (s errorStoreMock) exec(query string, limits timeouts) error {
 	if s.errExec {
 		return exampleErr
 	}
-	return s.wrapped.exec(query)
+	return s.wrapped.exec(query, limits)
 }
 
 var (
@@ -189,8 +525,17 @@ type errorStoreMock struct {
 	errFetchLastMigrationSerial                bool
 	errInsertLogs                              bool
 	errFetchLastMigrationIndexes               bool
+	errFetchMissingMigrationIndexes            bool
 	errFetchReverseMigrationIndexesAfterSerial bool
 	errDeleteLogs                              bool
+	errUpdateLogs                              bool
+	errInsertRepairLogs                        bool
+	errFetchSchemaSnapshot                     bool
+	errInsertSchemaSnapshot                    bool
+	errFetchLastSchemaSnapshot                 bool
+	errDeleteSchemaSnapshotsAfterSerial        bool
+	errFetchEnvironment                        bool
+	errSaveEnvironment                         bool
 	errBegin                                   bool
 	errRollback                                bool
 	errCommit                                  bool
@@ -0,0 +1,139 @@
+package dbmigrat
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// parseSingleFile splits content of single file migration into up and down sections.
+// Lines outside of sections must be blank or SQL comments.
+func parseSingleFile(data string) (*Migration, error) {
+	var migration Migration
+	var upLines, downLines []string
+	var section direction
+	for _, line := range strings.Split(data, "\n") {
+		name, ok := parseDirectiveLine(line)
+		if !ok {
+			switch section {
+			case up:
+				upLines = append(upLines, line)
+			case down:
+				downLines = append(downLines, line)
+			default:
+				trimmed := strings.TrimSpace(line)
+				if trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+					return nil, ErrContentOutsideSection
+				}
+			}
+			continue
+		}
+		switch name {
+		case directiveUp:
+			if section != "" {
+				return nil, ErrSectionsOrder
+			}
+			section = up
+		case directiveDown:
+			if section != up {
+				return nil, ErrSectionsOrder
+			}
+			section = down
+		default:
+			err := applyDirective(name, &migration)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if section == "" || (section == up && !migration.Irreversible) {
+		return nil, ErrMissingSection
+	}
+	migration.Up = strings.TrimSpace(strings.Join(upLines, "\n"))
+	migration.Down = strings.TrimSpace(strings.Join(downLines, "\n"))
+
+	return &migration, nil
+}
+
+// parseDirectives applies directives found in file of two files migration.
+// Section directives are not allowed there.
+func parseDirectives(data string, migration *Migration) error {
+	for _, line := range strings.Split(data, "\n") {
+		name, ok := parseDirectiveLine(line)
+		if !ok {
+			continue
+		}
+		if name == directiveUp || name == directiveDown {
+			return ErrSectionInTwoFiles
+		}
+		err := applyDirective(name, migration)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyDirective(directive string, migration *Migration) error {
+	fields := strings.Fields(directive)
+	if len(fields) == 0 {
+		return ErrUnknownDirective
+	}
+	name, args := fields[0], fields[1:]
+	switch name {
+	case directiveNoTransaction, directiveIrreversible:
+		if len(args) > 0 {
+			return ErrUnknownDirective
+		}
+		if name == directiveNoTransaction {
+			migration.NoTransaction = true
+		} else {
+			migration.Irreversible = true
+		}
+	case directiveStatementTimeout, directiveLockTimeout:
+		if len(args) != 1 {
+			return ErrTimeoutDirective
+		}
+		timeout, err := time.ParseDuration(args[0])
+		if err != nil || timeout <= 0 {
+			return ErrTimeoutDirective
+		}
+		if name == directiveStatementTimeout {
+			migration.StatementTimeout = timeout
+		} else {
+			migration.LockTimeout = timeout
+		}
+	default:
+		return ErrUnknownDirective
+	}
+	return nil
+}
+
+func parseDirectiveLine(line string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, directivePrefix) {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(trimmed, directivePrefix)), true
+}
+
+const (
+	directivePrefix        = "-- +dbmigrat "
+	directiveUp            = "Up"
+	directiveDown          = "Down"
+	directiveNoTransaction = "NoTransaction"
+	directiveIrreversible  = "Irreversible"
+
+	directiveStatementTimeout = "StatementTimeout"
+	directiveLockTimeout      = "LockTimeout"
+)
+
+// Errors reported by ReadDir func for invalid dbmigrat directives.
+var (
+	ErrContentOutsideSection = errors.New(`single file migration contains SQL outside of "-- +dbmigrat Up" and "-- +dbmigrat Down" sections`)
+	ErrSectionsOrder         = errors.New(`single file migration must contain "-- +dbmigrat Up" section followed by "-- +dbmigrat Down" section`)
+	ErrMissingSection        = errors.New(`single file migration must contain "-- +dbmigrat Up" and "-- +dbmigrat Down" (unless irreversible) sections`)
+	ErrSectionInTwoFiles     = errors.New("up and down files must not contain section directives")
+	ErrUnknownDirective      = errors.New("unknown dbmigrat directive")
+	ErrTimeoutDirective      = errors.New(`timeout directive must have positive duration argument (eg. "-- +dbmigrat LockTimeout 5s")`)
+)
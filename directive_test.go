@@ -0,0 +1,79 @@
+package dbmigrat
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseSingleFile(t *testing.T) {
+	t.Run("valid file", func(t *testing.T) {
+		res, err := parseSingleFile(`-- adds index on username
+-- +dbmigrat NoTransaction
+
+-- +dbmigrat Up
+create index concurrently users_username_idx on users (username);
+
+-- +dbmigrat Down
+drop index concurrently users_username_idx;
+`)
+		assert.NoError(t, err)
+		assert.Equal(t, &Migration{
+			Up:            "create index concurrently users_username_idx on users (username);",
+			Down:          "drop index concurrently users_username_idx;",
+			NoTransaction: true,
+		}, res)
+	})
+	t.Run("irreversible without down section", func(t *testing.T) {
+		res, err := parseSingleFile("-- +dbmigrat Up\n-- +dbmigrat Irreversible\nalter table users drop column legacy;\n")
+		assert.NoError(t, err)
+		assert.Equal(t, &Migration{Up: "alter table users drop column legacy;", Irreversible: true}, res)
+	})
+	t.Run("content outside of sections", func(t *testing.T) {
+		res, err := parseSingleFile("select 1;\n-- +dbmigrat Up\n-- +dbmigrat Down\n")
+		assert.Nil(t, res)
+		assert.EqualError(t, err, ErrContentOutsideSection.Error())
+	})
+	t.Run("down section before up section", func(t *testing.T) {
+		res, err := parseSingleFile("-- +dbmigrat Down\n-- +dbmigrat Up\n")
+		assert.Nil(t, res)
+		assert.EqualError(t, err, ErrSectionsOrder.Error())
+	})
+	t.Run("missing down section", func(t *testing.T) {
+		res, err := parseSingleFile("-- +dbmigrat Up\nselect 1;\n")
+		assert.Nil(t, res)
+		assert.EqualError(t, err, ErrMissingSection.Error())
+	})
+	t.Run("unknown directive", func(t *testing.T) {
+		res, err := parseSingleFile("-- +dbmigrat Foo\n-- +dbmigrat Up\n-- +dbmigrat Down\n")
+		assert.Nil(t, res)
+		assert.EqualError(t, err, ErrUnknownDirective.Error())
+	})
+}
+
+func TestParseDirectives(t *testing.T) {
+	t.Run("no directives", func(t *testing.T) {
+		var migration Migration
+		assert.NoError(t, parseDirectives("create table users (id serial primary key);", &migration))
+		assert.Equal(t, Migration{}, migration)
+	})
+	t.Run("transaction mode directive", func(t *testing.T) {
+		var migration Migration
+		assert.NoError(t, parseDirectives("-- +dbmigrat NoTransaction\ncreate index concurrently foo on bar (baz);", &migration))
+		assert.True(t, migration.NoTransaction)
+	})
+	t.Run("section directive", func(t *testing.T) {
+		var migration Migration
+		assert.EqualError(t, parseDirectives("-- +dbmigrat Up\nselect 1;", &migration), ErrSectionInTwoFiles.Error())
+	})
+	t.Run("timeout directives", func(t *testing.T) {
+		var migration Migration
+		assert.NoError(t, parseDirectives("-- +dbmigrat StatementTimeout 30s\n-- +dbmigrat LockTimeout 500ms\nalter table users add column email varchar(255);", &migration))
+		assert.Equal(t, Migration{StatementTimeout: 30 * time.Second, LockTimeout: 500 * time.Millisecond}, migration)
+
+		for _, directive := range []string{"LockTimeout", "LockTimeout soon", "LockTimeout 0s", "StatementTimeout 1s 2s"} {
+			assert.EqualError(t, parseDirectives("-- +dbmigrat "+directive, &migration), ErrTimeoutDirective.Error(), directive)
+		}
+		assert.EqualError(t, parseDirectives("-- +dbmigrat NoTransaction please", &migration), ErrUnknownDirective.Error())
+	})
+}
@@ -0,0 +1,70 @@
+package dbmigrat
+
+import (
+	"errors"
+	"sort"
+)
+
+// DetectDrift compares live database schema with schema snapshot captured
+// by the last run of Migrate. It allows for detecting changes made outside of migrations
+// (eg. alter table run by hand), which are not reflected by checksums verified by CheckLogTableIntegrity.
+func DetectDrift(s store) (*DriftResult, error) {
+	migrationSerial, recorded, err := s.fetchLastSchemaSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	if migrationSerial == -1 {
+		return nil, errNoSchemaSnapshot
+	}
+	live, err := s.fetchSchemaSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DriftResult{MigrationSerial: migrationSerial}
+	result.Added, result.Removed = recorded.diff(live)
+	result.HasDrift = len(result.Added) > 0 || len(result.Removed) > 0
+
+	return result, nil
+}
+
+// DriftResult contains differences between live database schema
+// and schema snapshot captured after applying migrations with MigrationSerial.
+//
+// Added contains objects present in live schema only,
+// Removed contains objects present in snapshot only.
+// Altered object is reported as removed (previous definition) and added (current definition).
+type DriftResult struct {
+	HasDrift        bool
+	MigrationSerial int
+	Added           SchemaSnapshot
+	Removed         SchemaSnapshot
+}
+
+// SchemaSnapshot is normalized description of database schema.
+// Every entry describes single table, column, constraint or index, entries are sorted.
+type SchemaSnapshot []string
+
+func (ss SchemaSnapshot) diff(other SchemaSnapshot) (added SchemaSnapshot, removed SchemaSnapshot) {
+	inSs := make(map[string]bool, len(ss))
+	for _, entry := range ss {
+		inSs[entry] = true
+	}
+	inOther := make(map[string]bool, len(other))
+	for _, entry := range other {
+		inOther[entry] = true
+		if !inSs[entry] {
+			added = append(added, entry)
+		}
+	}
+	for _, entry := range ss {
+		if !inOther[entry] {
+			removed = append(removed, entry)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+var errNoSchemaSnapshot = errors.New("schema snapshot not found. Snapshot is captured by Migrate func")
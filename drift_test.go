@@ -0,0 +1,84 @@
+package dbmigrat
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDetectDrift(t *testing.T) {
+	before := func(t *testing.T) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+	}
+
+	t.Run("no snapshot captured yet", func(t *testing.T) {
+		before(t)
+
+		res, err := DetectDrift(th.pgStore)
+		assert.EqualError(t, err, errNoSchemaSnapshot.Error())
+		assert.Nil(t, res)
+	})
+
+	t.Run("schema not changed outside of migrations", func(t *testing.T) {
+		before(t)
+		_, err := Migrate(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"})
+		assert.NoError(t, err)
+		_, err = Migrate(th.pgStore, th.migrations2, RepoOrder{"auth", "billing", "delivery"})
+		assert.NoError(t, err)
+
+		res, err := DetectDrift(th.pgStore)
+		assert.NoError(t, err)
+		assert.Equal(t, &DriftResult{HasDrift: false, MigrationSerial: 1}, res)
+
+		// # Check if snapshot follows rolled back migrations
+		_, err = Rollback(th.pgStore, th.migrations2, RepoOrder{"delivery", "billing", "auth"}, 0)
+		assert.NoError(t, err)
+		res, err = DetectDrift(th.pgStore)
+		assert.NoError(t, err)
+		assert.Equal(t, &DriftResult{HasDrift: false, MigrationSerial: 0}, res)
+	})
+
+	t.Run("schema changed by hand", func(t *testing.T) {
+		before(t)
+		_, err := Migrate(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"})
+		assert.NoError(t, err)
+		_, err = th.db.Exec(`alter table users alter column username type varchar(64)`)
+		assert.NoError(t, err)
+
+		res, err := DetectDrift(th.pgStore)
+		assert.NoError(t, err)
+		assert.Equal(t, &DriftResult{
+			HasDrift:        true,
+			MigrationSerial: 0,
+			Added:           SchemaSnapshot{"column public.users.username character varying(64)"},
+			Removed:         SchemaSnapshot{"column public.users.username character varying(32)"},
+		}, res)
+	})
+
+	t.Run("db error", func(t *testing.T) {
+		before(t)
+		_, err := Migrate(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"})
+		assert.NoError(t, err)
+
+		res, err := DetectDrift(errorStoreMock{wrapped: th.pgStore, errFetchLastSchemaSnapshot: true})
+		assert.EqualError(t, err, exampleErr.Error())
+		assert.Nil(t, res)
+
+		res, err = DetectDrift(errorStoreMock{wrapped: th.pgStore, errFetchSchemaSnapshot: true})
+		assert.EqualError(t, err, exampleErr.Error())
+		assert.Nil(t, res)
+	})
+}
+
+func TestSchemaSnapshotDiff(t *testing.T) {
+	t.Run("equal snapshots", func(t *testing.T) {
+		added, removed := SchemaSnapshot{"a", "b"}.diff(SchemaSnapshot{"a", "b"})
+		assert.Nil(t, added)
+		assert.Nil(t, removed)
+	})
+	t.Run("different snapshots", func(t *testing.T) {
+		added, removed := SchemaSnapshot{"a", "b", "c"}.diff(SchemaSnapshot{"d", "b", "a0"})
+		assert.Equal(t, SchemaSnapshot{"a0", "d"}, added)
+		assert.Equal(t, SchemaSnapshot{"a", "c"}, removed)
+	})
+}
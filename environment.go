@@ -0,0 +1,72 @@
+package dbmigrat
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-multierror"
+)
+
+// Environment tags database with name (eg. "production"). When it is Protected, funcs which
+// may destroy data or rewrite migrations log (Rollback, Baseline, FakeApply, FakeRollback, Repair, SetEnvironment)
+// refuse to run (return ErrProtectedEnvironment) unless WithConfirmation option carries the environment name.
+type Environment struct {
+	Name      string `db:"name" json:"name"`
+	Protected bool   `db:"protected" json:"protected"`
+}
+
+// SetEnvironment tags database with environment. Changing already protected environment
+// requires the same confirmation as other protected operations.
+func SetEnvironment(s store, environment Environment, opts ...Option) error {
+	if environment.Name == "" {
+		return ErrEmptyEnvironmentName
+	}
+	err := s.begin()
+	if err != nil {
+		return err
+	}
+	err = checkProtection(s, newOptions(opts))
+	if err == nil {
+		err = s.saveEnvironment(environment)
+	}
+	if err != nil {
+		return multierror.Append(err, s.rollback())
+	}
+	return s.commit()
+}
+
+// FetchEnvironment returns environment saved by SetEnvironment func or nil when database is not tagged
+// (also when its table has not been created yet by CreateLogTable).
+func FetchEnvironment(s store) (*Environment, error) {
+	return s.fetchEnvironment()
+}
+
+// checkProtection returns error when database is tagged with protected environment
+// and passed confirmation does not match its name.
+func checkProtection(s store, opts options) error {
+	environment, err := s.fetchEnvironment()
+	if err != nil {
+		return err
+	}
+	if environment == nil || !environment.Protected || opts.confirmation == environment.Name {
+		return nil
+	}
+	return errWithEnvironment{inner: ErrProtectedEnvironment, name: environment.Name}
+}
+
+var (
+	ErrProtectedEnvironment = errors.New("operation on protected environment requires confirmation with environment name")
+	ErrEmptyEnvironmentName = errors.New("environment name must not be empty")
+)
+
+func (e errWithEnvironment) Error() string {
+	return fmt.Sprintf("%s (%s)", e.inner.Error(), e.name)
+}
+
+func (e errWithEnvironment) Unwrap() error {
+	return e.inner
+}
+
+type errWithEnvironment struct {
+	inner error
+	name  string
+}
@@ -0,0 +1,101 @@
+package dbmigrat
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEnvironment(t *testing.T) {
+	before := func(t *testing.T) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+		_, err := Migrate(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"})
+		assert.NoError(t, err)
+	}
+
+	t.Run("untagged database is not protected", func(t *testing.T) {
+		before(t)
+
+		environment, err := FetchEnvironment(th.pgStore)
+		assert.NoError(t, err)
+		assert.Nil(t, environment)
+		assert.NoError(t, FakeRollback(th.pgStore, th.migrations1, "billing", 0))
+	})
+
+	t.Run("protected environment requires confirmation", func(t *testing.T) {
+		before(t)
+		assert.NoError(t, SetEnvironment(th.pgStore, Environment{Name: "production", Protected: true}))
+		environment, err := FetchEnvironment(th.pgStore)
+		assert.NoError(t, err)
+		assert.Equal(t, &Environment{Name: "production", Protected: true}, environment)
+
+		expectedErr := errWithEnvironment{inner: ErrProtectedEnvironment, name: "production"}
+		_, err = Rollback(th.pgStore, th.migrations1, RepoOrder{"billing", "auth"}, -1)
+		assert.True(t, errors.Is(err, ErrProtectedEnvironment))
+		assert.Contains(t, err.Error(), expectedErr.Error())
+		_, err = Rollback(th.pgStore, th.migrations1, RepoOrder{"billing", "auth"}, -1, WithConfirmation("staging"))
+		assert.True(t, errors.Is(err, ErrProtectedEnvironment))
+		err = FakeApply(th.pgStore, th.migrations2, "billing", 1)
+		assert.True(t, errors.Is(err, ErrProtectedEnvironment))
+		err = FakeRollback(th.pgStore, th.migrations1, "billing", 0)
+		assert.True(t, errors.Is(err, ErrProtectedEnvironment))
+		_, err = Repair(th.pgStore, th.migrations1, RepoIndexes{"auth": {0}}, "john")
+		assert.True(t, errors.Is(err, ErrProtectedEnvironment))
+		_, err = Baseline(th.pgStore, th.migrations2, map[Repo]int{"billing": 1})
+		assert.True(t, errors.Is(err, ErrProtectedEnvironment))
+		err = SetEnvironment(th.pgStore, Environment{Name: "production"})
+		assert.True(t, errors.Is(err, ErrProtectedEnvironment))
+
+		// # Nothing has been rolled back
+		var logsCount int
+		assert.NoError(t, th.db.Get(&logsCount, `select count(*) from dbmigrat_log`))
+		assert.Equal(t, 3, logsCount)
+
+		// # Migrate is not protected
+		_, err = Migrate(th.pgStore, th.migrations2, RepoOrder{"auth", "billing", "delivery"})
+		assert.NoError(t, err)
+
+		deletedLogs, err := Rollback(th.pgStore, th.migrations2, RepoOrder{"delivery", "billing", "auth"}, -1, WithConfirmation("production"))
+		assert.NoError(t, err)
+		assert.Equal(t, 5, deletedLogs)
+	})
+
+	t.Run("database without environment table is not tagged", func(t *testing.T) {
+		before(t)
+		// # Log table created by version without environments
+		_, err := th.db.Exec(`drop table dbmigrat_environment`)
+		assert.NoError(t, err)
+
+		environment, err := FetchEnvironment(th.pgStore)
+		assert.NoError(t, err)
+		assert.Nil(t, environment)
+		deletedLogs, err := Rollback(th.pgStore, th.migrations1, RepoOrder{"billing"}, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, deletedLogs)
+
+		assert.NoError(t, SetEnvironment(th.pgStore, Environment{Name: "staging"}))
+		environment, err = FetchEnvironment(th.pgStore)
+		assert.NoError(t, err)
+		assert.Equal(t, &Environment{Name: "staging"}, environment)
+	})
+
+	t.Run("unprotected environment does not require confirmation", func(t *testing.T) {
+		before(t)
+		assert.NoError(t, SetEnvironment(th.pgStore, Environment{Name: "production", Protected: true}))
+		assert.NoError(t, SetEnvironment(th.pgStore, Environment{Name: "staging"}, WithConfirmation("production")))
+
+		_, err := Rollback(th.pgStore, th.migrations1, RepoOrder{"billing", "auth"}, -1)
+		assert.NoError(t, err)
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		before(t)
+		assert.Equal(t, ErrEmptyEnvironmentName, SetEnvironment(th.pgStore, Environment{Protected: true}))
+
+		err := SetEnvironment(errorStoreMock{wrapped: th.pgStore, errSaveEnvironment: true}, Environment{Name: "production"})
+		assert.True(t, errors.Is(err, exampleErr))
+		_, err = Rollback(errorStoreMock{wrapped: th.pgStore, errFetchEnvironment: true}, th.migrations1, RepoOrder{"billing", "auth"}, -1)
+		assert.True(t, errors.Is(err, exampleErr))
+	})
+}
@@ -0,0 +1,111 @@
+package dbmigrat
+
+import (
+	"errors"
+	"github.com/hashicorp/go-multierror"
+)
+
+// FakeApply saves migration in migrations log without running its Up SQL.
+// It is meant for recording migration which has been applied manually (eg. hotfix).
+//
+// Migration must exist in passed migrations and must be the next one
+// to apply in its repo (every preceding migration must be already in log).
+// Saved migration gets its own migration serial.
+// On database tagged with protected Environment it requires WithConfirmation option.
+func FakeApply(s store, migrations Migrations, repo Repo, idx int, opts ...Option) error {
+	err := s.begin()
+	if err != nil {
+		return err
+	}
+	err = checkProtection(s, newOptions(opts))
+	if err == nil {
+		err = fakeApply(s, migrations, repo, idx)
+	}
+	if err != nil {
+		return multierror.Append(err, s.rollback())
+	}
+	return s.commit()
+}
+
+func fakeApply(s store, migrations Migrations, repo Repo, idx int) error {
+	if idx < 0 || idx >= len(migrations[repo]) {
+		return errWithRepoIdx{inner: errFakeMigrationNotFound, repo: repo, idx: idx}
+	}
+	lastMigrationIdx, err := fetchLastMigrationIdx(s, repo)
+	if err != nil {
+		return err
+	}
+	if idx != lastMigrationIdx+1 {
+		return errWithRepoIdx{inner: errFakeApplyNotNext, repo: repo, idx: idx}
+	}
+	lastMigrationSerial, err := s.fetchLastMigrationSerial()
+	if err != nil {
+		return err
+	}
+
+	return s.insertLogs([]MigrationLog{{
+		Idx:             idx,
+		Repo:            repo,
+		MigrationSerial: lastMigrationSerial + 1,
+		Checksum:        sha1Checksum(migrations[repo][idx].Up),
+		Description:     migrations[repo][idx].Description,
+		Version:         migrations[repo][idx].Version,
+	}})
+}
+
+// FakeRollback removes migration from migrations log without running its Down SQL.
+// It is meant for recording migration which has been reverted manually.
+//
+// Migration must exist in passed migrations and must be the last one
+// applied in its repo. On database tagged with protected Environment it requires WithConfirmation option.
+//
+// Migration serials are not renumbered. When removed migration is the only one of its migration serial
+// and newer serials exist, the serial is missing in log afterwards and CheckLogTableIntegrity
+// reports it in MissingSerials (Rollback never leaves such gap, as it removes all newer serials).
+func FakeRollback(s store, migrations Migrations, repo Repo, idx int, opts ...Option) error {
+	err := s.begin()
+	if err != nil {
+		return err
+	}
+	err = checkProtection(s, newOptions(opts))
+	if err == nil {
+		err = fakeRollback(s, migrations, repo, idx)
+	}
+	if err != nil {
+		return multierror.Append(err, s.rollback())
+	}
+	return s.commit()
+}
+
+func fakeRollback(s store, migrations Migrations, repo Repo, idx int) error {
+	if idx < 0 || idx >= len(migrations[repo]) {
+		return errWithRepoIdx{inner: errFakeMigrationNotFound, repo: repo, idx: idx}
+	}
+	lastMigrationIdx, err := fetchLastMigrationIdx(s, repo)
+	if err != nil {
+		return err
+	}
+	if idx != lastMigrationIdx {
+		return errWithRepoIdx{inner: errFakeRollbackNotLast, repo: repo, idx: idx}
+	}
+
+	return s.deleteLogs([]MigrationLog{{Idx: idx, Repo: repo}})
+}
+
+func fetchLastMigrationIdx(s store, repo Repo) (int, error) {
+	lastMigrationIndexes, err := s.fetchLastMigrationIndexes()
+	if err != nil {
+		return -1, err
+	}
+	lastMigrationIdx, ok := lastMigrationIndexes[repo]
+	if !ok {
+		return -1, nil
+	}
+	return lastMigrationIdx, nil
+}
+
+var (
+	errFakeMigrationNotFound = errors.New("migration does not exist in passed migrations")
+	errFakeApplyNotNext      = errors.New("only next migration to apply in repo can be fake applied")
+	errFakeRollbackNotLast   = errors.New("only last applied migration in repo can be fake rolled back")
+)
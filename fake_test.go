@@ -0,0 +1,127 @@
+package dbmigrat
+
+import (
+	"github.com/hashicorp/go-multierror"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFakeApply(t *testing.T) {
+	before := func(t *testing.T) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+		_, err := Migrate(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"})
+		assert.NoError(t, err)
+	}
+
+	t.Run("saves migration in log without running it", func(t *testing.T) {
+		before(t)
+
+		assert.NoError(t, FakeApply(th.pgStore, th.migrations2, "billing", 1))
+
+		var migrationLogs []MigrationLog
+		assert.NoError(t, th.db.Select(&migrationLogs, `select * from dbmigrat_log where repo = 'billing' and idx = 1`))
+		assert.Len(t, migrationLogs, 1)
+		assert.Equal(t, 1, migrationLogs[0].MigrationSerial)
+		assert.Equal(t, sha1Checksum(th.migrations2["billing"][1].Up), migrationLogs[0].Checksum)
+		assert.Equal(t, th.migrations2["billing"][1].Description, migrationLogs[0].Description)
+
+		var columnsCount int
+		assert.NoError(t, th.db.Get(&columnsCount, `select count(*) from information_schema.columns where table_name = 'orders' and column_name = 'value_gross'`))
+		assert.Equal(t, 0, columnsCount)
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		before(t)
+
+		err := FakeApply(th.pgStore, th.migrations2, "billing", 2)
+		assert.EqualError(t, err, multierror.Append(errWithRepoIdx{inner: errFakeMigrationNotFound, repo: "billing", idx: 2}).Error())
+
+		err = FakeApply(th.pgStore, th.migrations2, "auth", 1)
+		assert.EqualError(t, err, multierror.Append(errWithRepoIdx{inner: errFakeApplyNotNext, repo: "auth", idx: 1}).Error())
+	})
+
+	t.Run("error", func(t *testing.T) {
+		before(t)
+
+		caseTable := caseTable{
+			{name: "tx begin fail", storeMock: errorStoreMock{wrapped: th.pgStore, errBegin: true}, errExpected: exampleErr},
+			{name: "fetchLastMigrationIndexes fail", storeMock: errorStoreMock{wrapped: th.pgStore, errFetchLastMigrationIndexes: true}, errExpected: exampleMultiErr},
+			{name: "fetchLastMigrationSerial fail", storeMock: errorStoreMock{wrapped: th.pgStore, errFetchLastMigrationSerial: true}, errExpected: exampleMultiErr},
+			{name: "insertLogs fail", storeMock: errorStoreMock{wrapped: th.pgStore, errInsertLogs: true}, errExpected: exampleMultiErr},
+		}
+
+		for _, testCase := range caseTable {
+			t.Run(testCase.name, func(t *testing.T) {
+				err := FakeApply(testCase.storeMock, th.migrations2, "billing", 1)
+				assert.EqualError(t, err, testCase.errExpected.Error())
+			})
+		}
+	})
+}
+
+func TestFakeRollback(t *testing.T) {
+	before := func(t *testing.T) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+		_, err := Migrate(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"})
+		assert.NoError(t, err)
+	}
+
+	t.Run("removes migration from log without running it", func(t *testing.T) {
+		before(t)
+
+		assert.NoError(t, FakeRollback(th.pgStore, th.migrations1, "auth", 1))
+
+		lastIndexes, err := th.pgStore.fetchLastMigrationIndexes()
+		assert.NoError(t, err)
+		assert.Equal(t, map[Repo]int{"auth": 0, "billing": 0}, lastIndexes)
+
+		var columnsCount int
+		assert.NoError(t, th.db.Get(&columnsCount, `select count(*) from information_schema.columns where table_name = 'users' and column_name = 'username'`))
+		assert.Equal(t, 1, columnsCount)
+	})
+
+	t.Run("leaves missing serial reported by integrity check", func(t *testing.T) {
+		before(t)
+		assert.NoError(t, FakeApply(th.pgStore, th.migrations2, "billing", 1))
+		_, err := Migrate(th.pgStore, th.migrations2, RepoOrder{"auth", "billing", "delivery"})
+		assert.NoError(t, err)
+
+		assert.NoError(t, FakeRollback(th.pgStore, th.migrations2, "billing", 1))
+
+		checkRes, err := CheckLogTableIntegrity(th.pgStore, th.migrations2)
+		assert.NoError(t, err)
+		assert.True(t, checkRes.IsCorrupted)
+		assert.Equal(t, []int{1}, checkRes.MissingSerials)
+		assert.Empty(t, checkRes.MissingMigrations)
+		assert.Empty(t, checkRes.OutOfOrderMigrations)
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		before(t)
+
+		err := FakeRollback(th.pgStore, th.migrations1, "auth", 2)
+		assert.EqualError(t, err, multierror.Append(errWithRepoIdx{inner: errFakeMigrationNotFound, repo: "auth", idx: 2}).Error())
+
+		err = FakeRollback(th.pgStore, th.migrations1, "auth", 0)
+		assert.EqualError(t, err, multierror.Append(errWithRepoIdx{inner: errFakeRollbackNotLast, repo: "auth", idx: 0}).Error())
+	})
+
+	t.Run("error", func(t *testing.T) {
+		before(t)
+
+		caseTable := caseTable{
+			{name: "tx begin fail", storeMock: errorStoreMock{wrapped: th.pgStore, errBegin: true}, errExpected: exampleErr},
+			{name: "fetchLastMigrationIndexes fail", storeMock: errorStoreMock{wrapped: th.pgStore, errFetchLastMigrationIndexes: true}, errExpected: exampleMultiErr},
+			{name: "deleteLogs fail", storeMock: errorStoreMock{wrapped: th.pgStore, errDeleteLogs: true}, errExpected: exampleMultiErr},
+		}
+
+		for _, testCase := range caseTable {
+			t.Run(testCase.name, func(t *testing.T) {
+				err := FakeRollback(testCase.storeMock, th.migrations1, "auth", 1)
+				assert.EqualError(t, err, testCase.errExpected.Error())
+			})
+		}
+	})
+}
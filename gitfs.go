@@ -0,0 +1,604 @@
+package dbmigrat
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GitFS returns fs.FS with content of given revision of local git repository,
+// which might be passed to ReadDir, ReadRepos or ReadManifest funcs.
+// It allows for reading migrations "as of" released commit or tag instead of working tree.
+//
+// gitDir is path to ".git" directory (or to bare repository). Objects are read directly
+// from object database (loose objects and packs), git executable is not required.
+//
+// revision might be "HEAD", branch name, tag name (annotated tags are peeled),
+// full reference name (eg. "refs/tags/v1.2.0") or full or abbreviated (at least 4 characters) commit hash.
+//
+// Symbolic links and submodules are not exposed.
+func GitFS(gitDir string, revision string) (fs.FS, error) {
+	repo := &gitRepo{dir: gitDir}
+	err := repo.loadPackIndexes()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := repo.resolveRevision(revision)
+	if err != nil {
+		return nil, err
+	}
+	treeHash, err := repo.peelToTree(hash)
+	if err != nil {
+		return nil, err
+	}
+	return &gitFS{repo: repo, rootTree: treeHash}, nil
+}
+
+type gitFS struct {
+	repo     *gitRepo
+	rootTree string
+}
+
+func (gfs *gitFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	entry, err := gfs.lookup(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if !entry.isDir {
+		data, err := gfs.repo.readObjectOfType(entry.hash, gitBlob)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &memFile{entry: &memEntry{name: path.Base(name), data: data}, reader: bytes.NewReader(data)}, nil
+	}
+	children, err := gfs.children(entry.hash)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &memDir{entry: &memEntry{name: path.Base(name), isDir: true}, children: children}, nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (gfs *gitFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	file, err := gfs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := file.(*memDir)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return dir.ReadDir(-1)
+}
+
+// lookup walks trees from root tree to entry under name.
+func (gfs *gitFS) lookup(name string) (gitTreeEntry, error) {
+	entry := gitTreeEntry{hash: gfs.rootTree, isDir: true}
+	if name == "." {
+		return entry, nil
+	}
+	for _, element := range strings.Split(name, "/") {
+		if !entry.isDir {
+			return gitTreeEntry{}, fs.ErrNotExist
+		}
+		entries, err := gfs.repo.readTree(entry.hash)
+		if err != nil {
+			return gitTreeEntry{}, err
+		}
+		found := false
+		for _, candidate := range entries {
+			if candidate.name == element {
+				entry, found = candidate, true
+				break
+			}
+		}
+		if !found {
+			return gitTreeEntry{}, fs.ErrNotExist
+		}
+	}
+	return entry, nil
+}
+
+// children returns entries of tree. Blobs are not read until Info of their entry is requested.
+func (gfs *gitFS) children(treeHash string) ([]fs.DirEntry, error) {
+	entries, err := gfs.repo.readTree(treeHash)
+	if err != nil {
+		return nil, err
+	}
+	children := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.isDir {
+			children = append(children, &memEntry{name: entry.name, isDir: true})
+			continue
+		}
+		children = append(children, &gitBlobEntry{repo: gfs.repo, name: entry.name, hash: entry.hash})
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].Name() < children[j].Name() })
+	return children, nil
+}
+
+// gitBlobEntry is fs.DirEntry of file, its blob is read only by Info (to tell size of file),
+// so listing directory does not inflate every file in it.
+type gitBlobEntry struct {
+	repo *gitRepo
+	name string
+	hash string
+}
+
+func (e *gitBlobEntry) Name() string      { return e.name }
+func (e *gitBlobEntry) IsDir() bool       { return false }
+func (e *gitBlobEntry) Type() fs.FileMode { return 0 }
+func (e *gitBlobEntry) Info() (fs.FileInfo, error) {
+	data, err := e.repo.readObjectOfType(e.hash, gitBlob)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: e.name, Err: err}
+	}
+	return &memEntry{name: e.name, data: data}, nil
+}
+
+type gitRepo struct {
+	dir   string
+	packs []*gitPack
+}
+
+type gitPack struct {
+	path    string
+	hashes  []string
+	offsets map[string]int64
+}
+
+type gitTreeEntry struct {
+	name  string
+	hash  string
+	isDir bool
+}
+
+type gitObjectType int
+
+const (
+	gitCommit   gitObjectType = 1
+	gitTree     gitObjectType = 2
+	gitBlob     gitObjectType = 3
+	gitTag      gitObjectType = 4
+	gitOfsDelta gitObjectType = 6
+	gitRefDelta gitObjectType = 7
+)
+
+var gitObjectTypeNames = map[string]gitObjectType{"commit": gitCommit, "tree": gitTree, "blob": gitBlob, "tag": gitTag}
+
+// resolveRevision returns hash of object pointed by revision.
+func (r *gitRepo) resolveRevision(revision string) (string, error) {
+	if revision == "" || strings.HasPrefix(revision, "/") || strings.Contains(revision, "..") {
+		return "", errWithFileName{inner: ErrGitRevisionNotFound, fileName: revision}
+	}
+	candidates := []string{revision}
+	if !strings.HasPrefix(revision, "refs/") && revision != "HEAD" {
+		candidates = append(candidates, "refs/tags/"+revision, "refs/heads/"+revision, "refs/remotes/"+revision)
+	}
+	for _, refName := range candidates {
+		hash, err := r.resolveRef(refName, 0)
+		if err != nil {
+			return "", err
+		}
+		if hash != "" {
+			return hash, nil
+		}
+	}
+	if len(revision) >= 4 && isHex(revision) {
+		return r.resolveHashPrefix(strings.ToLower(revision))
+	}
+	return "", errWithFileName{inner: ErrGitRevisionNotFound, fileName: revision}
+}
+
+// resolveRef returns hash pointed by reference (following symbolic references)
+// or empty string when reference does not exist.
+func (r *gitRepo) resolveRef(refName string, depth int) (string, error) {
+	if depth > 5 {
+		return "", errWithFileName{inner: ErrGitRevisionNotFound, fileName: refName}
+	}
+	data, err := ioutil.ReadFile(filepath.Join(r.dir, filepath.FromSlash(refName)))
+	if err == nil {
+		content := strings.TrimSpace(string(data))
+		if strings.HasPrefix(content, "ref: ") {
+			return r.resolveRef(strings.TrimPrefix(content, "ref: "), depth+1)
+		}
+		if len(content) != 40 || !isHex(content) {
+			return "", nil
+		}
+		return content, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) && !isDirError(err) {
+		return "", err
+	}
+
+	packedRefs, err := os.Open(filepath.Join(r.dir, "packed-refs"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer packedRefs.Close()
+	scanner := bufio.NewScanner(packedRefs)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == refName {
+			return fields[0], nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// resolveHashPrefix returns hash of the only object starting with prefix.
+func (r *gitRepo) resolveHashPrefix(prefix string) (string, error) {
+	found := map[string]bool{}
+	if len(prefix) == 40 {
+		found[prefix] = true
+	}
+	looseDirEntries, err := ioutil.ReadDir(filepath.Join(r.dir, "objects", prefix[:2]))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	for _, dirEntry := range looseDirEntries {
+		if strings.HasPrefix(prefix[:2]+dirEntry.Name(), prefix) {
+			found[prefix[:2]+dirEntry.Name()] = true
+		}
+	}
+	for _, pack := range r.packs {
+		start := sort.SearchStrings(pack.hashes, prefix)
+		for i := start; i < len(pack.hashes) && strings.HasPrefix(pack.hashes[i], prefix); i++ {
+			found[pack.hashes[i]] = true
+		}
+	}
+	if len(found) != 1 {
+		return "", errWithFileName{inner: ErrGitRevisionNotFound, fileName: prefix}
+	}
+	for hash := range found {
+		return hash, nil
+	}
+	return "", nil
+}
+
+// peelToTree returns hash of tree of commit (or of commit pointed by annotated tag).
+func (r *gitRepo) peelToTree(hash string) (string, error) {
+	for {
+		objectType, data, err := r.readObject(hash)
+		if err != nil {
+			return "", err
+		}
+		switch objectType {
+		case gitTree:
+			return hash, nil
+		case gitCommit, gitTag:
+			prefix := "tree "
+			if objectType == gitTag {
+				prefix = "object "
+			}
+			next := ""
+			for _, line := range strings.Split(string(data), "\n") {
+				if strings.HasPrefix(line, prefix) {
+					next = strings.TrimPrefix(line, prefix)
+					break
+				}
+			}
+			if next == "" {
+				return "", errWithFileName{inner: ErrGitCorruptedObject, fileName: hash}
+			}
+			hash = next
+		default:
+			return "", errWithFileName{inner: ErrGitRevisionNotFound, fileName: hash}
+		}
+	}
+}
+
+func (r *gitRepo) readTree(hash string) ([]gitTreeEntry, error) {
+	data, err := r.readObjectOfType(hash, gitTree)
+	if err != nil {
+		return nil, err
+	}
+	var entries []gitTreeEntry
+	for len(data) > 0 {
+		nulPos := bytes.IndexByte(data, 0)
+		if nulPos == -1 || len(data) < nulPos+21 {
+			return nil, errWithFileName{inner: ErrGitCorruptedObject, fileName: hash}
+		}
+		modeAndName := strings.SplitN(string(data[:nulPos]), " ", 2)
+		if len(modeAndName) != 2 {
+			return nil, errWithFileName{inner: ErrGitCorruptedObject, fileName: hash}
+		}
+		entryHash := hex.EncodeToString(data[nulPos+1 : nulPos+21])
+		data = data[nulPos+21:]
+		switch modeAndName[0] {
+		case "40000":
+			entries = append(entries, gitTreeEntry{name: modeAndName[1], hash: entryHash, isDir: true})
+		case "100644", "100755", "100664":
+			entries = append(entries, gitTreeEntry{name: modeAndName[1], hash: entryHash})
+		}
+	}
+	return entries, nil
+}
+
+func (r *gitRepo) readObjectOfType(hash string, expectedType gitObjectType) ([]byte, error) {
+	objectType, data, err := r.readObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	if objectType != expectedType {
+		return nil, errWithFileName{inner: ErrGitCorruptedObject, fileName: hash}
+	}
+	return data, nil
+}
+
+// readObject reads loose object or object from one of packs.
+func (r *gitRepo) readObject(hash string) (gitObjectType, []byte, error) {
+	if len(hash) != 40 || !isHex(hash) {
+		return 0, nil, errWithFileName{inner: ErrGitCorruptedObject, fileName: hash}
+	}
+	f, err := os.Open(filepath.Join(r.dir, "objects", hash[:2], hash[2:]))
+	if err == nil {
+		defer f.Close()
+		return readLooseObject(f, hash)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return 0, nil, err
+	}
+	for _, pack := range r.packs {
+		offset, ok := pack.offsets[hash]
+		if ok {
+			return r.readPackObject(pack, offset)
+		}
+	}
+	return 0, nil, errWithFileName{inner: ErrGitObjectNotFound, fileName: hash}
+}
+
+func readLooseObject(f io.Reader, hash string) (gitObjectType, []byte, error) {
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer zr.Close()
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return 0, nil, err
+	}
+	nulPos := bytes.IndexByte(data, 0)
+	if nulPos == -1 {
+		return 0, nil, errWithFileName{inner: ErrGitCorruptedObject, fileName: hash}
+	}
+	typeAndSize := strings.SplitN(string(data[:nulPos]), " ", 2)
+	objectType, ok := gitObjectTypeNames[typeAndSize[0]]
+	if !ok || len(typeAndSize) != 2 || typeAndSize[1] != strconv.Itoa(len(data)-nulPos-1) {
+		return 0, nil, errWithFileName{inner: ErrGitCorruptedObject, fileName: hash}
+	}
+	return objectType, data[nulPos+1:], nil
+}
+
+// loadPackIndexes reads version 2 indexes of all packs.
+func (r *gitRepo) loadPackIndexes() error {
+	idxPaths, err := filepath.Glob(filepath.Join(r.dir, "objects", "pack", "*.idx"))
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(r.dir, "objects")); err != nil {
+		return err
+	}
+	for _, idxPath := range idxPaths {
+		data, err := ioutil.ReadFile(idxPath)
+		if err != nil {
+			return err
+		}
+		pack, err := parsePackIndex(data)
+		if err != nil {
+			return errWithFileName{inner: err, fileName: idxPath}
+		}
+		pack.path = strings.TrimSuffix(idxPath, ".idx") + ".pack"
+		r.packs = append(r.packs, pack)
+	}
+	return nil
+}
+
+func parsePackIndex(data []byte) (*gitPack, error) {
+	const headerLen = 8 + 256*4
+	if len(data) < headerLen || !bytes.Equal(data[:8], []byte{0xff, 't', 'O', 'c', 0, 0, 0, 2}) {
+		return nil, ErrGitCorruptedObject
+	}
+	count := int(binary.BigEndian.Uint32(data[headerLen-4 : headerLen]))
+	hashesPos := headerLen
+	offsetsPos := hashesPos + count*20 + count*4
+	largeOffsetsPos := offsetsPos + count*4
+	if len(data) < largeOffsetsPos {
+		return nil, ErrGitCorruptedObject
+	}
+	pack := &gitPack{hashes: make([]string, count), offsets: make(map[string]int64, count)}
+	for i := 0; i < count; i++ {
+		hash := hex.EncodeToString(data[hashesPos+i*20 : hashesPos+(i+1)*20])
+		offset := int64(binary.BigEndian.Uint32(data[offsetsPos+i*4:]))
+		if offset&0x80000000 != 0 {
+			largePos := largeOffsetsPos + int(offset&0x7fffffff)*8
+			if len(data) < largePos+8 {
+				return nil, ErrGitCorruptedObject
+			}
+			offset = int64(binary.BigEndian.Uint64(data[largePos:]))
+		}
+		pack.hashes[i] = hash
+		pack.offsets[hash] = offset
+	}
+	return pack, nil
+}
+
+// readPackObject reads object stored in pack at offset, resolving deltas.
+func (r *gitRepo) readPackObject(pack *gitPack, offset int64) (gitObjectType, []byte, error) {
+	f, err := os.Open(pack.path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	reader := bufio.NewReader(io.NewSectionReader(f, offset, 1<<62))
+
+	b, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	objectType := gitObjectType((b >> 4) & 7)
+	for b&0x80 != 0 {
+		b, err = reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	var baseType gitObjectType
+	var base []byte
+	switch objectType {
+	case gitCommit, gitTree, gitBlob, gitTag:
+	case gitOfsDelta:
+		b, err = reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		baseDistance := int64(b & 0x7f)
+		for b&0x80 != 0 {
+			b, err = reader.ReadByte()
+			if err != nil {
+				return 0, nil, err
+			}
+			baseDistance = ((baseDistance + 1) << 7) | int64(b&0x7f)
+		}
+		baseType, base, err = r.readPackObject(pack, offset-baseDistance)
+		if err != nil {
+			return 0, nil, err
+		}
+	case gitRefDelta:
+		baseHash := make([]byte, 20)
+		_, err = io.ReadFull(reader, baseHash)
+		if err != nil {
+			return 0, nil, err
+		}
+		baseType, base, err = r.readObject(hex.EncodeToString(baseHash))
+		if err != nil {
+			return 0, nil, err
+		}
+	default:
+		return 0, nil, errWithFileName{inner: ErrGitCorruptedObject, fileName: fmt.Sprintf("%s@%d", pack.path, offset)}
+	}
+
+	zr, err := zlib.NewReader(reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer zr.Close()
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return 0, nil, err
+	}
+	if base == nil {
+		return objectType, data, nil
+	}
+	data, err = applyGitDelta(base, data)
+	if err != nil {
+		return 0, nil, errWithFileName{inner: err, fileName: fmt.Sprintf("%s@%d", pack.path, offset)}
+	}
+	return baseType, data, nil
+}
+
+// applyGitDelta builds object from base object and delta consisting of copy and insert instructions.
+func applyGitDelta(base, delta []byte) ([]byte, error) {
+	baseSize, delta := readDeltaSize(delta)
+	if baseSize != len(base) {
+		return nil, ErrGitCorruptedObject
+	}
+	resultSize, delta := readDeltaSize(delta)
+	result := make([]byte, 0, resultSize)
+	for len(delta) > 0 {
+		cmd := delta[0]
+		delta = delta[1:]
+		if cmd&0x80 == 0 {
+			if cmd == 0 || int(cmd) > len(delta) {
+				return nil, ErrGitCorruptedObject
+			}
+			result = append(result, delta[:cmd]...)
+			delta = delta[cmd:]
+			continue
+		}
+		var copyOffset, copySize int
+		for i := uint(0); i < 7; i++ {
+			if cmd&(1<<i) == 0 {
+				continue
+			}
+			if len(delta) == 0 {
+				return nil, ErrGitCorruptedObject
+			}
+			if i < 4 {
+				copyOffset |= int(delta[0]) << (8 * i)
+			} else {
+				copySize |= int(delta[0]) << (8 * (i - 4))
+			}
+			delta = delta[1:]
+		}
+		if copySize == 0 {
+			copySize = 0x10000
+		}
+		if copyOffset+copySize > len(base) {
+			return nil, ErrGitCorruptedObject
+		}
+		result = append(result, base[copyOffset:copyOffset+copySize]...)
+	}
+	if len(result) != resultSize {
+		return nil, ErrGitCorruptedObject
+	}
+	return result, nil
+}
+
+func readDeltaSize(delta []byte) (int, []byte) {
+	size, shift := 0, uint(0)
+	for len(delta) > 0 {
+		b := delta[0]
+		delta = delta[1:]
+		size |= int(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return size, delta
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(strings.Repeat("0", len(s)%2) + s)
+	return err == nil
+}
+
+// isDirError tells if err has been returned for reading directory as file
+// (eg. "refs/heads/feature" when there is branch "feature/x").
+func isDirError(err error) bool {
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) {
+		return false
+	}
+	info, statErr := os.Stat(pathErr.Path)
+	return statErr == nil && info.IsDir()
+}
+
+// Errors reported by GitFS func and returned by its file system.
+// They are wrapped with revision or object hash, use errors.Is for checking them.
+var (
+	ErrGitRevisionNotFound = errors.New("git revision not found (or abbreviated hash is ambiguous)")
+	ErrGitObjectNotFound   = errors.New("git object not found")
+	ErrGitCorruptedObject  = errors.New("git object is corrupted or has unexpected type")
+)
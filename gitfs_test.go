@@ -0,0 +1,96 @@
+package dbmigrat
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestGitFS(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git executable is required for preparing repository")
+	}
+	workTree, err := ioutil.TempDir("", "dbmigrat_gitfs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(workTree)
+	gitDir := filepath.Join(workTree, ".git")
+
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=john", "-c", "user.email=john@example.com"}, args...)...)
+		cmd.Dir = workTree
+		out, err := cmd.CombinedOutput()
+		assert.NoError(t, err, string(out))
+		return strings.TrimSpace(string(out))
+	}
+	writeFile := func(name, data string) {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(workTree, name)), 0755))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(workTree, name), []byte(data), 0644))
+	}
+
+	git("init", "-q")
+	writeFile("auth/migrations/0.create_user_table.up.sql", "create table users (id serial primary key);")
+	writeFile("auth/migrations/0.create_user_table.down.sql", "drop table users;")
+	git("add", "-A")
+	git("commit", "-q", "-m", "v1")
+	git("tag", "-a", "v1.0.0", "-m", "release")
+	firstCommit := git("rev-parse", "HEAD")
+	writeFile("auth/migrations/1.add_username_column.up.sql", "alter table users add column username varchar(32);")
+	writeFile("auth/migrations/1.add_username_column.down.sql", "alter table users drop column username;")
+	git("add", "-A")
+	git("commit", "-q", "-m", "v2")
+	// # Uncommitted changes are not visible
+	writeFile("auth/migrations/2.add_email_column.up.sql", "alter table users add column email varchar(255);")
+	writeFile("auth/migrations/2.add_email_column.down.sql", "alter table users drop column email;")
+
+	v1 := Migrations{"auth": {{Description: "create_user_table", Up: "create table users (id serial primary key);", Down: "drop table users;"}}}
+	v2 := Migrations{"auth": append(v1["auth"], Migration{Description: "add_username_column", Up: "alter table users add column username varchar(32);", Down: "alter table users drop column username;"})}
+
+	assertRevisions := func(t *testing.T) {
+		for revision, expected := range map[string]Migrations{"v1.0.0": v1, "refs/tags/v1.0.0": v1, firstCommit: v1, firstCommit[:7]: v1, "HEAD": v2} {
+			fileSys, err := GitFS(gitDir, revision)
+			assert.NoError(t, err)
+			migrations, _, err := ReadRepos(fileSys, ".")
+			assert.NoError(t, err)
+			assert.Equal(t, expected, migrations, revision)
+		}
+		fileSys, err := GitFS(gitDir, "HEAD")
+		assert.NoError(t, err)
+		assert.NoError(t, fstest.TestFS(fileSys, "auth/migrations/1.add_username_column.up.sql"))
+
+		_, err = GitFS(gitDir, "v2.0.0")
+		assert.True(t, errors.Is(err, ErrGitRevisionNotFound))
+		_, err = GitFS(gitDir, "../../etc/passwd")
+		assert.True(t, errors.Is(err, ErrGitRevisionNotFound))
+	}
+
+	t.Run("reads loose objects", assertRevisions)
+	git("gc", "-q", "--aggressive")
+	t.Run("reads packed objects and refs", assertRevisions)
+
+	t.Run("lists directory without reading blobs", func(t *testing.T) {
+		// # Migration left uncommitted before
+		git("add", "-A")
+		git("commit", "-q", "-m", "v3")
+		blobHash := git("rev-parse", "HEAD:auth/migrations/2.add_email_column.up.sql")
+		assert.NoError(t, os.Remove(filepath.Join(gitDir, "objects", blobHash[:2], blobHash[2:])))
+
+		fileSys, err := GitFS(gitDir, "HEAD")
+		assert.NoError(t, err)
+		entries, err := fs.ReadDir(fileSys, "auth/migrations")
+		assert.NoError(t, err)
+		assert.Len(t, entries, 6)
+		info, err := entries[0].Info()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len("drop table users;")), info.Size())
+		assert.Equal(t, "2.add_email_column.up.sql", entries[5].Name())
+		_, err = entries[5].Info()
+		assert.True(t, errors.Is(err, ErrGitObjectNotFound))
+	})
+}
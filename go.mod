@@ -3,8 +3,10 @@ module github.com/graaphscom/dbmigrat
 go 1.16
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/hashicorp/go-multierror v1.1.1
 	github.com/jmoiron/sqlx v1.3.4
 	github.com/lib/pq v1.10.2
 	github.com/stretchr/testify v1.7.0
+	gopkg.in/yaml.v3 v3.0.1
 )
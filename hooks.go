@@ -0,0 +1,209 @@
+package dbmigrat
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Hooks are callbacks called by Migrate and Rollback funcs (see WithHooks option).
+// Every hook is optional. Error returned by hook (except of AfterRun) stops the run and rolls back its transaction.
+//
+// Migrations applied before NoTransaction migration are committed along with changes made
+// by preceding hooks, NoTransaction migration itself receives Tx which is not a transaction.
+type Hooks struct {
+	// BeforeRun is called at the beginning of run, before migrations log is read.
+	BeforeRun func(event RunEvent) error
+	// AfterRun is called after transaction of the run has been committed, its Tx is not a transaction.
+	// Returned error is passed to OnError and returned along with number of committed migrations.
+	AfterRun func(event RunEvent) error
+	// BeforeMigration is called before running Up (or Down on Rollback) SQL of every migration.
+	BeforeMigration func(event MigrationEvent) error
+	// AfterMigration is called after Up (or Down on Rollback) SQL of migration succeeded.
+	AfterMigration func(event MigrationEvent) error
+	// OnError is called when run fails, before its transaction is rolled back. It is also called when
+	// transaction can not be began (eg. database is unreachable), when protected Environment refuses Rollback,
+	// when commit fails or when AfterRun returns error.
+	// migration is nil when failure is not related to single migration, its Tx is always nil.
+	OnError func(err error, migration *MigrationEvent)
+}
+
+// RunEvent describes single call to Migrate or Rollback func.
+type RunEvent struct {
+	// Rollback is true when hook is called by Rollback func.
+	Rollback bool
+	Tx       Tx
+}
+
+// MigrationEvent describes migration which is being applied or rolled back.
+type MigrationEvent struct {
+	Rollback    bool
+	Repo        Repo
+	Idx         int
+	Description string
+	Tx          Tx
+}
+
+// Tx executes queries within transaction of the run (or directly on database for NoTransaction migration).
+// It is implemented by *sqlx.Tx and *sqlx.DB.
+type Tx interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// hookRun calls hooks, logger and tracer of single Migrate or Rollback run and remembers migration being run,
+// so it can be passed to OnError hook.
+type hookRun struct {
+	hooks       Hooks
+	logger      Logger
+	tracer      Tracer
+	ctx         context.Context
+	rollback    bool
+	serial      int
+	started     time.Time
+	span        Span
+	current     *MigrationEvent
+	currentSpan Span
+}
+
+func newHookRun(opts options, rollback bool) *hookRun {
+	return &hookRun{
+		hooks:       opts.hooks,
+		logger:      opts.logger,
+		tracer:      opts.tracer,
+		ctx:         opts.traceCtx,
+		rollback:    rollback,
+		serial:      -1,
+		span:        nopSpan{},
+		currentSpan: nopSpan{},
+	}
+}
+
+// action names the run in log messages.
+func (hr *hookRun) action() string {
+	if hr.rollback {
+		return "rollback"
+	}
+	return "migrate"
+}
+
+func (hr *hookRun) beforeRun(s store) error {
+	hr.started = time.Now()
+	hr.logger.Info(hr.action() + " started")
+	if hr.rollback {
+		hr.ctx, hr.span = hr.tracer.Start(hr.ctx, "dbmigrat.Rollback")
+	} else {
+		hr.ctx, hr.span = hr.tracer.Start(hr.ctx, "dbmigrat.Migrate")
+	}
+	if hr.hooks.BeforeRun == nil {
+		return nil
+	}
+	return hr.hooks.BeforeRun(RunEvent{Rollback: hr.rollback, Tx: s.currentTx()})
+}
+
+// afterRun reports finished run, calls AfterRun hook and ends span of the run
+// after its transaction has been committed.
+func (hr *hookRun) afterRun(s store, migrationsCount int) error {
+	hr.logger.Info(hr.action()+" finished", "migrations", migrationsCount, "duration", time.Since(hr.started))
+	hr.span.SetAttributes(Attribute{Key: "dbmigrat.migrations", Value: migrationsCount})
+	if hr.hooks.AfterRun != nil {
+		err := hr.hooks.AfterRun(RunEvent{Rollback: hr.rollback, Tx: s.currentTx()})
+		if err != nil {
+			hr.onError(err)
+			return err
+		}
+	}
+	hr.end(nil)
+	return nil
+}
+
+// setSerial sets migration serial of migrations applied by the run.
+func (hr *hookRun) setSerial(serial int) {
+	hr.serial = serial
+	hr.span.SetAttributes(Attribute{Key: "dbmigrat.serial", Value: serial})
+}
+
+// setToSerial sets migration serial to which the run rolls back.
+func (hr *hookRun) setToSerial(serial int) {
+	hr.span.SetAttributes(Attribute{Key: "dbmigrat.to_serial", Value: serial})
+}
+
+// setRolledBackSerial sets migration serial of migration which is rolled back next.
+func (hr *hookRun) setRolledBackSerial(serial int) {
+	hr.serial = serial
+}
+
+// end ends span of the run.
+func (hr *hookRun) end(err error) {
+	if err != nil {
+		hr.span.RecordError(err)
+	}
+	hr.span.End()
+}
+
+// migration runs exec of migration query wrapped with BeforeMigration and AfterMigration hooks.
+func (hr *hookRun) migration(s store, repo Repo, idx int, migration Migration, query string, exec func() error) error {
+	event := MigrationEvent{Rollback: hr.rollback, Repo: repo, Idx: idx, Description: migration.Description}
+	hr.current = &event
+	event.Tx = s.currentTx()
+	attributes := []Attribute{
+		{Key: "dbmigrat.repo", Value: string(repo)},
+		{Key: "dbmigrat.idx", Value: idx},
+		{Key: "dbmigrat.description", Value: migration.Description},
+		{Key: "dbmigrat.statement_size", Value: len(query)},
+	}
+	if hr.serial >= 0 {
+		attributes = append(attributes, Attribute{Key: "dbmigrat.serial", Value: hr.serial})
+	}
+	_, hr.currentSpan = hr.tracer.Start(hr.ctx, "dbmigrat.migration", attributes...)
+	if hr.rollback {
+		hr.logger.Info("rolling back migration", "repo", string(repo), "idx", idx, "description", migration.Description)
+	} else {
+		hr.logger.Info("applying migration", "repo", string(repo), "idx", idx, "description", migration.Description)
+	}
+	started := time.Now()
+	if hr.hooks.BeforeMigration != nil {
+		err := hr.hooks.BeforeMigration(event)
+		if err != nil {
+			return err
+		}
+	}
+	err := exec()
+	if err != nil {
+		return err
+	}
+	if hr.hooks.AfterMigration != nil {
+		err = hr.hooks.AfterMigration(event)
+		if err != nil {
+			return err
+		}
+	}
+	if hr.rollback {
+		hr.logger.Info("migration rolled back", "repo", string(repo), "idx", idx, "description", migration.Description, "duration", time.Since(started))
+	} else {
+		hr.logger.Info("migration applied", "repo", string(repo), "idx", idx, "description", migration.Description, "duration", time.Since(started))
+	}
+	hr.currentSpan.End()
+	hr.current, hr.currentSpan = nil, nopSpan{}
+	return nil
+}
+
+// onError reports failure of the run and ends its spans.
+func (hr *hookRun) onError(err error) {
+	var migration *MigrationEvent
+	if hr.current != nil {
+		hr.currentSpan.RecordError(err)
+		hr.currentSpan.End()
+		failed := *hr.current
+		failed.Tx = nil
+		migration = &failed
+		hr.logger.Error(hr.action()+" failed", "error", err, "repo", string(failed.Repo), "idx", failed.Idx, "description", failed.Description)
+	} else {
+		hr.logger.Error(hr.action()+" failed", "error", err)
+	}
+	if hr.hooks.OnError != nil {
+		hr.hooks.OnError(err, migration)
+	}
+	hr.end(err)
+}
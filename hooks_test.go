@@ -0,0 +1,155 @@
+package dbmigrat
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	var calls []string
+	hooks := Hooks{
+		BeforeRun: func(event RunEvent) error {
+			calls = append(calls, fmt.Sprintf("before run (rollback: %t)", event.Rollback))
+			_, err := event.Tx.Exec(`set local lock_timeout = '5s'`)
+			return err
+		},
+		AfterRun: func(event RunEvent) error {
+			calls = append(calls, fmt.Sprintf("after run (rollback: %t)", event.Rollback))
+			return nil
+		},
+		BeforeMigration: func(event MigrationEvent) error {
+			var lockTimeout string
+			err := event.Tx.QueryRow(`show lock_timeout`).Scan(&lockTimeout)
+			calls = append(calls, fmt.Sprintf("before %s:%d %s (lock timeout: %s)", event.Repo, event.Idx, event.Description, lockTimeout))
+			return err
+		},
+		AfterMigration: func(event MigrationEvent) error {
+			calls = append(calls, fmt.Sprintf("after %s:%d", event.Repo, event.Idx))
+			return nil
+		},
+		OnError: func(err error, migration *MigrationEvent) {
+			if migration == nil {
+				calls = append(calls, "error")
+				return
+			}
+			calls = append(calls, fmt.Sprintf("error %s:%d", migration.Repo, migration.Idx))
+		},
+	}
+
+	t.Run("hooks are called around run and every migration", func(t *testing.T) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+		calls = nil
+
+		_, err := Migrate(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"}, WithHooks(hooks))
+		assert.NoError(t, err)
+		_, err = Rollback(th.pgStore, th.migrations1, RepoOrder{"billing", "auth"}, 0, WithHooks(hooks))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{
+			"before run (rollback: false)",
+			"before auth:0 create user table (lock timeout: 5s)",
+			"after auth:0",
+			"before auth:1 add username column (lock timeout: 5s)",
+			"after auth:1",
+			"before billing:0 create orders table (lock timeout: 5s)",
+			"after billing:0",
+			"after run (rollback: false)",
+			"before run (rollback: true)",
+			"after run (rollback: true)",
+		}, calls)
+	})
+
+	t.Run("error of migration is passed to OnError", func(t *testing.T) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+		calls = nil
+		failing := Migrations{"auth": {th.migrations1["auth"][0], {Up: `alter table non_existing add column id integer`, Description: "failing"}}}
+
+		_, err := Migrate(th.pgStore, failing, RepoOrder{"auth"}, WithHooks(hooks))
+		assert.Error(t, err)
+		assert.Equal(t, []string{
+			"before run (rollback: false)",
+			"before auth:0 create user table (lock timeout: 5s)",
+			"after auth:0",
+			"before auth:1 failing (lock timeout: 5s)",
+			"error auth:1",
+		}, calls)
+	})
+
+	t.Run("error of hook stops run", func(t *testing.T) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+		calls = nil
+		hookErr := errors.New("lock not acquired")
+		failingHooks := hooks
+		failingHooks.BeforeRun = func(event RunEvent) error {
+			return hookErr
+		}
+
+		logCount, err := Migrate(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"}, WithHooks(failingHooks))
+		assert.True(t, errors.Is(err, hookErr))
+		assert.Equal(t, 0, logCount)
+		assert.Equal(t, []string{"error"}, calls)
+
+		lastIndexes, err := th.pgStore.fetchLastMigrationIndexes()
+		assert.NoError(t, err)
+		assert.Empty(t, lastIndexes)
+	})
+
+	t.Run("AfterRun is called after commit", func(t *testing.T) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+		calls = nil
+		hookErr := errors.New("cache invalidation failed")
+		failingHooks := hooks
+		failingHooks.AfterRun = func(event RunEvent) error {
+			var logCount int
+			err := event.Tx.QueryRow(`select count(*) from dbmigrat_log`).Scan(&logCount)
+			calls = append(calls, fmt.Sprintf("after run (committed logs: %d)", logCount))
+			if err != nil {
+				return err
+			}
+			return hookErr
+		}
+
+		logCount, err := Migrate(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"}, WithHooks(failingHooks))
+		assert.Equal(t, hookErr, err)
+		assert.Equal(t, 3, logCount)
+		assert.Equal(t, []string{"after run (committed logs: 3)", "error"}, calls[len(calls)-2:])
+	})
+
+	t.Run("errors of commit and protected environment are passed to OnError", func(t *testing.T) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+		calls = nil
+
+		logCount, err := Migrate(errorStoreMock{wrapped: th.pgStore, errCommit: true}, th.migrations1, RepoOrder{"auth", "billing"}, WithHooks(hooks))
+		assert.Equal(t, exampleErr, err)
+		assert.Equal(t, 0, logCount)
+		assert.NoError(t, th.pgStore.rollback())
+		assert.Equal(t, "error", calls[len(calls)-1])
+		assert.NotContains(t, calls, "after run (rollback: false)")
+
+		_, err = Migrate(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"})
+		assert.NoError(t, err)
+		assert.NoError(t, SetEnvironment(th.pgStore, Environment{Name: "production", Protected: true}))
+		calls = nil
+		logCount, err = Rollback(th.pgStore, th.migrations1, RepoOrder{"billing", "auth"}, -1, WithHooks(hooks))
+		assert.True(t, errors.Is(err, ErrProtectedEnvironment))
+		assert.Equal(t, 0, logCount)
+		assert.Equal(t, []string{"error"}, calls)
+	})
+
+	t.Run("error of transaction begin is passed to OnError", func(t *testing.T) {
+		calls = nil
+		logCount, err := Migrate(errorStoreMock{wrapped: th.pgStore, errBegin: true}, th.migrations1, RepoOrder{"auth", "billing"}, WithHooks(hooks))
+		assert.Equal(t, exampleErr, err)
+		assert.Equal(t, 0, logCount)
+		logCount, err = Rollback(errorStoreMock{wrapped: th.pgStore, errBegin: true}, th.migrations1, RepoOrder{"billing", "auth"}, -1, WithHooks(hooks))
+		assert.Equal(t, exampleErr, err)
+		assert.Equal(t, 0, logCount)
+		assert.Equal(t, []string{"error", "error"}, calls)
+	})
+}
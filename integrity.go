@@ -1,15 +1,32 @@
 package dbmigrat
 
+import "sort"
+
 // CheckLogTableIntegrity compares provided migrations with saved ones in migration log.
 // It returns error when log contains migrations not present in migrations passed as argument to this func.
-func CheckLogTableIntegrity(s store, migrations Migrations) (*IntegrityCheckResult, error) {
+//
+// Besides comparing with provided migrations, it checks consistency of the log itself:
+// missing indexes below the last applied index of repo, migrations applied
+// in order other than their indexes and migration serials which are not contiguous.
+//
+// Found problems are reported as warnings to logger passed with WithLogger option.
+func CheckLogTableIntegrity(s store, migrations Migrations, opts ...Option) (*IntegrityCheckResult, error) {
 	migrationLogs, err := s.fetchAllMigrationLogs()
 
 	if err != nil {
 		return nil, err
 	}
 
+	result := checkLogIntegrity(migrationLogs, migrations)
+	logIntegrityResult(newOptions(opts).logger, result)
+
+	return result, nil
+}
+
+// checkLogIntegrity compares fetched migrations log with provided migrations.
+func checkLogIntegrity(migrationLogs []MigrationLog, migrations Migrations) *IntegrityCheckResult {
 	result := newIntegrityCheckResult()
+	checkLogConsistency(migrationLogs, result)
 
 	for _, log := range migrationLogs {
 		repoMigrations, ok := migrations[log.Repo]
@@ -24,29 +41,95 @@ func CheckLogTableIntegrity(s store, migrations Migrations) (*IntegrityCheckResu
 			continue
 		}
 
+		if log.Version != "" && log.Version != repoMigrations[log.Idx].Version {
+			result.IsCorrupted = true
+			result.ReorderedMigrations[log.Repo] = append(result.ReorderedMigrations[log.Repo], log)
+		}
+
 		if log.Checksum != sha1Checksum(repoMigrations[log.Idx].Up) {
 			result.IsCorrupted = true
-			result.InvalidChecksums[log.Repo] = append(result.RedundantMigrations[log.Repo], log)
+			result.InvalidChecksums[log.Repo] = append(result.InvalidChecksums[log.Repo], log)
 		}
 	}
 
-	return result, nil
+	return result
+}
+
+func checkLogConsistency(migrationLogs []MigrationLog, result *IntegrityCheckResult) {
+	sortedLogs := make([]MigrationLog, len(migrationLogs))
+	copy(sortedLogs, migrationLogs)
+	sort.Slice(sortedLogs, func(i, j int) bool {
+		if sortedLogs[i].Repo == sortedLogs[j].Repo {
+			return sortedLogs[i].Idx < sortedLogs[j].Idx
+		}
+		return sortedLogs[i].Repo < sortedLogs[j].Repo
+	})
+
+	serials := map[int]bool{}
+	lastSerial := -1
+	expectedIdx, maxRepoSerial := 0, -1
+	for i, log := range sortedLogs {
+		if i == 0 || sortedLogs[i-1].Repo != log.Repo {
+			expectedIdx, maxRepoSerial = 0, -1
+		}
+		for missingIdx := expectedIdx; missingIdx < log.Idx; missingIdx++ {
+			result.IsCorrupted = true
+			result.MissingMigrations[log.Repo] = append(result.MissingMigrations[log.Repo], missingIdx)
+		}
+		if log.MigrationSerial < maxRepoSerial {
+			result.IsCorrupted = true
+			result.OutOfOrderMigrations[log.Repo] = append(result.OutOfOrderMigrations[log.Repo], log)
+		}
+
+		expectedIdx = log.Idx + 1
+		if log.MigrationSerial > maxRepoSerial && !log.GapFilled {
+			maxRepoSerial = log.MigrationSerial
+		}
+		if log.MigrationSerial > lastSerial {
+			lastSerial = log.MigrationSerial
+		}
+		serials[log.MigrationSerial] = true
+	}
+
+	for serial := 0; serial < lastSerial; serial++ {
+		if !serials[serial] {
+			result.IsCorrupted = true
+			result.MissingSerials = append(result.MissingSerials, serial)
+		}
+	}
 }
 
 func newIntegrityCheckResult() *IntegrityCheckResult {
 	return &IntegrityCheckResult{
-		IsCorrupted:         false,
-		RedundantRepos:      map[Repo]bool{},
-		RedundantMigrations: map[Repo][]migrationLog{},
-		InvalidChecksums:    map[Repo][]migrationLog{},
+		IsCorrupted:          false,
+		RedundantRepos:       map[Repo]bool{},
+		RedundantMigrations:  map[Repo][]MigrationLog{},
+		InvalidChecksums:     map[Repo][]MigrationLog{},
+		MissingMigrations:    map[Repo][]int{},
+		OutOfOrderMigrations: map[Repo][]MigrationLog{},
+		ReorderedMigrations:  map[Repo][]MigrationLog{},
+		MissingSerials:       []int{},
 	}
 }
 
 // IntegrityCheckResult contains information about objects which exist in DB log
 // but not in passed migrations to the CheckLogTableIntegrity func.
+//
+// MissingMigrations contains indexes absent in log but lower than the last applied index of repo.
+// OutOfOrderMigrations contains logs with migration serial lower than serial of migration with lower index.
+// Serials of migrations applied by WithGapFilling option (see MigrationLog.GapFilled) are not compared.
+// MissingSerials contains migration serials absent in log but lower than the last migration serial
+// (eg. left by FakeRollback of the only migration of serial).
+// ReorderedMigrations contains logs which version differs from version of passed migration with the same index.
+//
+// Result is encoded to JSON with camelCase keys. All keys are always present, empty maps and lists are encoded as {} and [].
 type IntegrityCheckResult struct {
-	IsCorrupted         bool
-	RedundantRepos      map[Repo]bool
-	RedundantMigrations map[Repo][]migrationLog
-	InvalidChecksums    map[Repo][]migrationLog
+	IsCorrupted          bool                    `json:"isCorrupted"`
+	RedundantRepos       map[Repo]bool           `json:"redundantRepos"`
+	RedundantMigrations  map[Repo][]MigrationLog `json:"redundantMigrations"`
+	InvalidChecksums     map[Repo][]MigrationLog `json:"invalidChecksums"`
+	MissingMigrations    map[Repo][]int          `json:"missingMigrations"`
+	OutOfOrderMigrations map[Repo][]MigrationLog `json:"outOfOrderMigrations"`
+	ReorderedMigrations  map[Repo][]MigrationLog `json:"reorderedMigrations"`
+	MissingSerials       []int                   `json:"missingSerials"`
 }
@@ -1,8 +1,10 @@
 package dbmigrat
 
 import (
+	"encoding/json"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestCheckLogTableIntegrity(t *testing.T) {
@@ -37,7 +39,7 @@ func TestCheckLogTableIntegrity(t *testing.T) {
 	t.Run("Not corrupted log with one migration and extra migrations passed from outside", func(t *testing.T) {
 		assert.NoError(t, truncateLogTable())
 		upSql := "create table foo (id integer primary key)"
-		assert.NoError(t, th.pgStore.insertLogs([]migrationLog{{
+		assert.NoError(t, th.pgStore.insertLogs([]MigrationLog{{
 			Idx:             0,
 			Repo:            "repo1",
 			MigrationSerial: 0,
@@ -59,28 +61,28 @@ func TestCheckLogTableIntegrity(t *testing.T) {
 
 	t.Run("Corrupted log", func(t *testing.T) {
 		assert.NoError(t, truncateLogTable())
-		invalidChecksum := migrationLog{
+		invalidChecksum := MigrationLog{
 			Idx:             0,
 			Repo:            "repo1",
 			MigrationSerial: 0,
 			Checksum:        "",
 			Description:     "example migration invalid checksum",
 		}
-		redundantMigration := migrationLog{
+		redundantMigration := MigrationLog{
 			Idx:             1,
 			Repo:            "repo1",
 			MigrationSerial: 0,
 			Checksum:        sha1Checksum("example"),
 			Description:     "example redundant migration",
 		}
-		redundantRepo := migrationLog{
+		redundantRepo := MigrationLog{
 			Idx:             0,
 			Repo:            "repoRedundant",
 			MigrationSerial: 0,
 			Checksum:        sha1Checksum("example"),
 			Description:     "example migration redundant repo",
 		}
-		assert.NoError(t, th.pgStore.insertLogs([]migrationLog{invalidChecksum, redundantMigration, redundantRepo}))
+		assert.NoError(t, th.pgStore.insertLogs([]MigrationLog{invalidChecksum, redundantMigration, redundantRepo}))
 
 		result, err := CheckLogTableIntegrity(th.pgStore, Migrations{
 			"repo1": {
@@ -92,10 +94,62 @@ func TestCheckLogTableIntegrity(t *testing.T) {
 		redundantMigration.AppliedAt = result.RedundantMigrations["repo1"][0].AppliedAt
 		invalidChecksum.AppliedAt = result.InvalidChecksums["repo1"][0].AppliedAt
 		assert.Equal(t, &IntegrityCheckResult{
-			IsCorrupted:         true,
-			RedundantRepos:      map[Repo]bool{"repoRedundant": true},
-			RedundantMigrations: map[Repo][]migrationLog{"repo1": {redundantMigration}},
-			InvalidChecksums:    map[Repo][]migrationLog{"repo1": {invalidChecksum}},
+			IsCorrupted:          true,
+			RedundantRepos:       map[Repo]bool{"repoRedundant": true},
+			RedundantMigrations:  map[Repo][]MigrationLog{"repo1": {redundantMigration}},
+			InvalidChecksums:     map[Repo][]MigrationLog{"repo1": {invalidChecksum}},
+			MissingMigrations:    map[Repo][]int{},
+			OutOfOrderMigrations: map[Repo][]MigrationLog{},
+			ReorderedMigrations:  map[Repo][]MigrationLog{},
+			MissingSerials:       []int{},
+		}, result)
+	})
+
+	t.Run("Redundant migration and invalid checksum of the same repo are reported separately", func(t *testing.T) {
+		assert.NoError(t, truncateLogTable())
+		assert.NoError(t, th.pgStore.insertLogs([]MigrationLog{
+			{Idx: 1, Repo: "repo1", MigrationSerial: 0, Checksum: "redundant", Description: "redundant"},
+			{Idx: 0, Repo: "repo1", MigrationSerial: 0, Checksum: "invalid", Description: "invalid checksum"},
+		}))
+
+		result, err := CheckLogTableIntegrity(th.pgStore, Migrations{"repo1": {{Up: "sql other than stored in log"}}})
+		assert.NoError(t, err)
+		appliedAt := time.Date(2021, 10, 18, 14, 30, 0, 0, time.UTC)
+		result.RedundantMigrations["repo1"][0].AppliedAt = appliedAt
+		result.InvalidChecksums["repo1"][0].AppliedAt = appliedAt
+		encoded, err := json.Marshal(result)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"isCorrupted":true,"redundantRepos":{},`+
+			`"redundantMigrations":{"repo1":[{"idx":1,"repo":"repo1","migrationSerial":0,"checksum":"redundant","appliedAt":"2021-10-18T14:30:00Z","description":"redundant","version":"","gapFilled":false}]},`+
+			`"invalidChecksums":{"repo1":[{"idx":0,"repo":"repo1","migrationSerial":0,"checksum":"invalid","appliedAt":"2021-10-18T14:30:00Z","description":"invalid checksum","version":"","gapFilled":false}]},`+
+			`"missingMigrations":{},"outOfOrderMigrations":{},"reorderedMigrations":{},"missingSerials":[]}`, string(encoded))
+	})
+
+	t.Run("Log with gaps, out of order migrations and missing serials", func(t *testing.T) {
+		assert.NoError(t, truncateLogTable())
+		outOfOrder := MigrationLog{Idx: 3, Repo: "repo1", MigrationSerial: 0, Checksum: sha1Checksum("3")}
+		assert.NoError(t, th.pgStore.insertLogs([]MigrationLog{
+			{Idx: 0, Repo: "repo1", MigrationSerial: 0, Checksum: sha1Checksum("0")},
+			{Idx: 2, Repo: "repo1", MigrationSerial: 3, Checksum: sha1Checksum("2")},
+			outOfOrder,
+			{Idx: 0, Repo: "repo2", MigrationSerial: 3, Checksum: sha1Checksum("0")},
+		}))
+
+		result, err := CheckLogTableIntegrity(th.pgStore, Migrations{
+			"repo1": {{Up: "0"}, {Up: "1"}, {Up: "2"}, {Up: "3"}},
+			"repo2": {{Up: "0"}},
+		})
+		assert.NoError(t, err)
+		outOfOrder.AppliedAt = result.OutOfOrderMigrations["repo1"][0].AppliedAt
+		assert.Equal(t, &IntegrityCheckResult{
+			IsCorrupted:          true,
+			RedundantRepos:       map[Repo]bool{},
+			RedundantMigrations:  map[Repo][]MigrationLog{},
+			InvalidChecksums:     map[Repo][]MigrationLog{},
+			MissingMigrations:    map[Repo][]int{"repo1": {1}},
+			OutOfOrderMigrations: map[Repo][]MigrationLog{"repo1": {outOfOrder}},
+			ReorderedMigrations:  map[Repo][]MigrationLog{},
+			MissingSerials:       []int{1, 2},
 		}, result)
 	})
 
@@ -0,0 +1,62 @@
+package dbmigrat
+
+// Logger receives structured events of Migrate, Rollback and CheckLogTableIntegrity funcs (see WithLogger option).
+// Arguments following message are key-value pairs (eg. "repo", "auth", "idx", 0).
+// It is implemented by *slog.Logger from log/slog package.
+type Logger interface {
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+// logIntegrityResult emits warning for every problem found by CheckLogTableIntegrity.
+func logIntegrityResult(logger Logger, result *IntegrityCheckResult) {
+	if !result.IsCorrupted {
+		logger.Info("migrations log integrity check passed")
+		return
+	}
+	redundantRepos := make([]Repo, 0, len(result.RedundantRepos))
+	for repo := range result.RedundantRepos {
+		redundantRepos = append(redundantRepos, repo)
+	}
+	sortRepos(redundantRepos)
+	for _, repo := range redundantRepos {
+		logger.Warn("migrations log integrity problem", "problem", "redundant repo", "repo", string(repo))
+	}
+	logIntegrityLogs(logger, "redundant migration", result.RedundantMigrations)
+	logIntegrityLogs(logger, "invalid checksum", result.InvalidChecksums)
+	logIntegrityLogs(logger, "out of order migration", result.OutOfOrderMigrations)
+	logIntegrityLogs(logger, "reordered migration", result.ReorderedMigrations)
+	missingRepos := make([]Repo, 0, len(result.MissingMigrations))
+	for repo := range result.MissingMigrations {
+		missingRepos = append(missingRepos, repo)
+	}
+	sortRepos(missingRepos)
+	for _, repo := range missingRepos {
+		for _, idx := range result.MissingMigrations[repo] {
+			logger.Warn("migrations log integrity problem", "problem", "missing migration", "repo", string(repo), "idx", idx)
+		}
+	}
+	for _, serial := range result.MissingSerials {
+		logger.Warn("migrations log integrity problem", "problem", "missing serial", "serial", serial)
+	}
+}
+
+func logIntegrityLogs(logger Logger, problem string, logsByRepo map[Repo][]MigrationLog) {
+	repos := make([]Repo, 0, len(logsByRepo))
+	for repo := range logsByRepo {
+		repos = append(repos, repo)
+	}
+	sortRepos(repos)
+	for _, repo := range repos {
+		for _, log := range logsByRepo[repo] {
+			logger.Warn("migrations log integrity problem", "problem", problem, "repo", string(repo), "idx", log.Idx, "serial", log.MigrationSerial)
+		}
+	}
+}
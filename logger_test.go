@@ -0,0 +1,121 @@
+package dbmigrat
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLogger(t *testing.T) {
+	t.Run("logs run and every migration", func(t *testing.T) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+		logger := &recordingLogger{}
+
+		_, err := Migrate(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"}, WithLogger(logger))
+		assert.NoError(t, err)
+		_, err = Rollback(th.pgStore, th.migrations1, RepoOrder{"billing"}, -1, WithLogger(logger))
+		assert.NoError(t, err)
+		_, err = Migrate(th.pgStore, Migrations{"auth": {{Up: `alter table non_existing add column id integer`, Description: "failing"}}}, RepoOrder{"auth"}, WithLogger(logger))
+		assert.Error(t, err)
+
+		assert.Equal(t, []string{
+			"INFO migrate started",
+			"INFO applying migration repo=auth idx=0 description=create user table",
+			"INFO migration applied repo=auth idx=0 description=create user table duration=?",
+			"INFO applying migration repo=auth idx=1 description=add username column",
+			"INFO migration applied repo=auth idx=1 description=add username column duration=?",
+			"INFO applying migration repo=billing idx=0 description=create orders table",
+			"INFO migration applied repo=billing idx=0 description=create orders table duration=?",
+			"INFO migrate finished migrations=3 duration=?",
+			"INFO rollback started",
+			"INFO rolling back migration repo=billing idx=0 description=create orders table",
+			"INFO migration rolled back repo=billing idx=0 description=create orders table duration=?",
+			"INFO rollback finished migrations=1 duration=?",
+			"INFO migrate started",
+			"INFO applying migration repo=auth idx=0 description=failing",
+			`ERROR migrate failed error=pq: relation "non_existing" does not exist repo=auth idx=0 description=failing`,
+		}, logger.lines)
+	})
+
+	t.Run("logs errors of commit and protected environment", func(t *testing.T) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+		logger := &recordingLogger{}
+
+		_, err := Migrate(errorStoreMock{wrapped: th.pgStore, errCommit: true}, th.migrations1, RepoOrder{"auth"}, WithLogger(logger))
+		assert.Equal(t, exampleErr, err)
+		assert.NoError(t, th.pgStore.rollback())
+		assert.NoError(t, SetEnvironment(th.pgStore, Environment{Name: "production", Protected: true}))
+		_, err = Rollback(th.pgStore, th.migrations1, RepoOrder{"auth"}, -1, WithLogger(logger))
+		assert.True(t, errors.Is(err, ErrProtectedEnvironment))
+
+		assert.Equal(t, []string{
+			"INFO migrate started",
+			"INFO applying migration repo=auth idx=0 description=create user table",
+			"INFO migration applied repo=auth idx=0 description=create user table duration=?",
+			"INFO applying migration repo=auth idx=1 description=add username column",
+			"INFO migration applied repo=auth idx=1 description=add username column duration=?",
+			"ERROR migrate failed error=" + exampleErr.Error(),
+			"ERROR rollback failed error=" + errWithEnvironment{inner: ErrProtectedEnvironment, name: "production"}.Error(),
+		}, logger.lines)
+	})
+
+	t.Run("logs error of transaction begin", func(t *testing.T) {
+		logger := &recordingLogger{}
+
+		_, err := Migrate(errorStoreMock{wrapped: th.pgStore, errBegin: true}, th.migrations1, RepoOrder{"auth"}, WithLogger(logger))
+		assert.Equal(t, exampleErr, err)
+		_, err = Rollback(errorStoreMock{wrapped: th.pgStore, errBegin: true}, th.migrations1, RepoOrder{"auth"}, -1, WithLogger(logger))
+		assert.Equal(t, exampleErr, err)
+
+		assert.Equal(t, []string{
+			"ERROR migrate failed error=" + exampleErr.Error(),
+			"ERROR rollback failed error=" + exampleErr.Error(),
+		}, logger.lines)
+	})
+
+	t.Run("logs integrity problems", func(t *testing.T) {
+		logger := &recordingLogger{}
+		result := newIntegrityCheckResult()
+		logIntegrityResult(logger, result)
+		assert.Equal(t, []string{"INFO migrations log integrity check passed"}, logger.lines)
+
+		logger = &recordingLogger{}
+		result.IsCorrupted = true
+		result.RedundantRepos["delivery"] = true
+		result.InvalidChecksums["auth"] = []MigrationLog{{Repo: "auth", Idx: 1, MigrationSerial: 0}}
+		result.MissingMigrations["billing"] = []int{0}
+		result.MissingSerials = []int{2}
+		logIntegrityResult(logger, result)
+		assert.Equal(t, []string{
+			"WARN migrations log integrity problem problem=redundant repo repo=delivery",
+			"WARN migrations log integrity problem problem=invalid checksum repo=auth idx=1 serial=0",
+			"WARN migrations log integrity problem problem=missing migration repo=billing idx=0",
+			"WARN migrations log integrity problem problem=missing serial serial=2",
+		}, logger.lines)
+	})
+}
+
+// recordingLogger formats events as lines, durations are replaced with "?".
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Info(msg string, args ...interface{})  { l.record("INFO", msg, args) }
+func (l *recordingLogger) Warn(msg string, args ...interface{})  { l.record("WARN", msg, args) }
+func (l *recordingLogger) Error(msg string, args ...interface{}) { l.record("ERROR", msg, args) }
+
+func (l *recordingLogger) record(level, msg string, args []interface{}) {
+	line := level + " " + msg
+	for i := 0; i+1 < len(args); i += 2 {
+		value := args[i+1]
+		if _, ok := value.(time.Duration); ok {
+			value = "?"
+		}
+		line += fmt.Sprintf(" %v=%v", args[i], value)
+	}
+	l.lines = append(l.lines, line)
+}
@@ -0,0 +1,279 @@
+package dbmigrat
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/hashicorp/go-multierror"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadManifest is helper func which allows for reading migrations listed in manifest file
+// instead of encoding their metadata in files names. Format of manifest is chosen
+// by extension of file under manifestPath - ".yaml", ".yml" or ".json".
+//
+// Manifest lists migrations of every repo in order in which they should be applied.
+// Up and down SQL might be kept inline (upSql, downSql) or in files (up, down)
+// with paths relative to directory of manifest. Files might contain dbmigrat directives
+// (except of section directives), same as files read by ReadDir.
+// Down is not required for irreversible migration.
+//
+// Example of manifest:
+//
+//	repos:
+//	  auth:
+//	    - description: create users table
+//	      up: auth/0.create_users_table.up.sql
+//	      down: auth/0.create_users_table.down.sql
+//	      author: john
+//	      tags: [users]
+//	    - description: add username index
+//	      upSql: create index concurrently users_username_idx on users (username)
+//	      downSql: drop index users_username_idx
+//	      noTransaction: true
+//	  billing:
+//	    - description: create orders table
+//	      version: "20211018143000"
+//	      up: billing/orders.up.sql
+//	      down: billing/orders.down.sql
+//	      dependsOn: ["auth:0"]
+//	      timeout: 30s
+//	      lockTimeout: 5s
+//
+// Migration might depend only on earlier migrations of its repo and dependencies must not form a cycle
+// (taking into account that every migration follows previous one of its repo).
+// Errors of all migrations are collected, use errors.Is for checking them.
+func ReadManifest(fileSys fs.FS, manifestPath string) (Migrations, error) {
+	data, err := fs.ReadFile(fileSys, manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	var parsed manifest
+	switch path.Ext(manifestPath) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&parsed)
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&parsed)
+	default:
+		return nil, ErrManifestFormat
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	repos := make([]Repo, 0, len(parsed.Repos))
+	for repo := range parsed.Repos {
+		repos = append(repos, repo)
+	}
+	sortRepos(repos)
+	dir := path.Dir(manifestPath)
+	migrations := Migrations{}
+	var errs *multierror.Error
+	for _, repo := range repos {
+		var repoMigrations []Migration
+		for idx, entry := range parsed.Repos[repo] {
+			migration, err := entry.toMigration(fileSys, dir, repo, idx, parsed.Repos)
+			if err != nil {
+				errs = multierror.Append(errs, errWithRepoIdx{inner: err, repo: repo, idx: idx})
+				continue
+			}
+			if idx > 0 && migration.Version != "" && migration.Version <= parsed.Repos[repo][idx-1].Version {
+				errs = multierror.Append(errs, errWithRepoIdx{inner: ErrManifestVersionOrder, repo: repo, idx: idx})
+				continue
+			}
+			repoMigrations = append(repoMigrations, *migration)
+		}
+		migrations[repo] = repoMigrations
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	if ref, ok := findDependencyCycle(migrations); ok {
+		return nil, multierror.Append(errs, errWithRepoIdx{inner: ErrManifestDependencyCycle, repo: ref.Repo, idx: ref.Idx})
+	}
+
+	return migrations, nil
+}
+
+// findDependencyCycle returns migration which (transitively) depends on itself.
+// Every migration depends on its DependsOn and on previous migration of its repo.
+func findDependencyCycle(migrations Migrations) (MigrationRef, bool) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[MigrationRef]int{}
+	var visit func(ref MigrationRef) bool
+	visit = func(ref MigrationRef) bool {
+		switch state[ref] {
+		case visiting:
+			return true
+		case visited:
+			return false
+		}
+		state[ref] = visiting
+		dependencies := migrations[ref.Repo][ref.Idx].DependsOn
+		if ref.Idx > 0 {
+			dependencies = append([]MigrationRef{{Repo: ref.Repo, Idx: ref.Idx - 1}}, dependencies...)
+		}
+		for _, dependency := range dependencies {
+			if visit(dependency) {
+				return true
+			}
+		}
+		state[ref] = visited
+		return false
+	}
+
+	repos := make([]Repo, 0, len(migrations))
+	for repo := range migrations {
+		repos = append(repos, repo)
+	}
+	sortRepos(repos)
+	for _, repo := range repos {
+		for idx := range migrations[repo] {
+			ref := MigrationRef{Repo: repo, Idx: idx}
+			if visit(ref) {
+				return ref, true
+			}
+		}
+	}
+	return MigrationRef{}, false
+}
+
+// toMigration validates manifest entry and reads SQL from referenced files.
+func (mm manifestMigration) toMigration(fileSys fs.FS, dir string, repo Repo, idx int, repos map[Repo][]manifestMigration) (*Migration, error) {
+	migration := Migration{
+		Description:   mm.Description,
+		Version:       mm.Version,
+		Irreversible:  mm.Irreversible,
+		NoTransaction: mm.NoTransaction,
+		Tags:          mm.Tags,
+		Author:        mm.Author,
+	}
+	for _, t := range []struct {
+		value  string
+		target *time.Duration
+	}{
+		{value: mm.Timeout, target: &migration.StatementTimeout},
+		{value: mm.LockTimeout, target: &migration.LockTimeout},
+	} {
+		if t.value == "" {
+			continue
+		}
+		timeout, err := time.ParseDuration(t.value)
+		if err != nil || timeout <= 0 {
+			return nil, ErrManifestTimeout
+		}
+		*t.target = timeout
+	}
+	for _, dependency := range mm.DependsOn {
+		ref, err := parseMigrationRef(dependency)
+		if err != nil || ref.Idx >= len(repos[ref.Repo]) {
+			return nil, ErrManifestDependency
+		}
+		if ref.Repo == repo && ref.Idx >= idx {
+			return nil, ErrManifestDependencyOrder
+		}
+		migration.DependsOn = append(migration.DependsOn, ref)
+	}
+	if mm.Version != "" && strings.ContainsAny(mm.Version, "_.") {
+		return nil, ErrFileNameVersion
+	}
+
+	var err error
+	migration.Up, err = readManifestSQL(fileSys, dir, mm.Up, mm.UpSQL, &migration)
+	if err != nil {
+		return nil, err
+	}
+	if migration.Up == "" {
+		return nil, ErrManifestMissingUp
+	}
+	migration.Down, err = readManifestSQL(fileSys, dir, mm.Down, mm.DownSQL, &migration)
+	if err != nil {
+		return nil, err
+	}
+	if migration.Down == "" && !migration.Irreversible {
+		return nil, ErrManifestMissingDown
+	}
+
+	return &migration, nil
+}
+
+// readManifestSQL returns inline SQL or content of file under filePath (relative to dir of manifest).
+// Directives found in SQL are applied to migration.
+func readManifestSQL(fileSys fs.FS, dir, filePath, inlineSQL string, migration *Migration) (string, error) {
+	if filePath != "" && inlineSQL != "" {
+		return "", ErrManifestPathAndSQL
+	}
+	query := inlineSQL
+	if filePath != "" {
+		data, err := fs.ReadFile(fileSys, path.Join(dir, filePath))
+		if err != nil {
+			return "", err
+		}
+		query = string(data)
+	}
+	err := parseDirectives(query, migration)
+	if err != nil {
+		return "", err
+	}
+	return query, nil
+}
+
+// parseMigrationRef parses reference to migration in form "repo:idx".
+func parseMigrationRef(ref string) (MigrationRef, error) {
+	separatorPos := strings.LastIndex(ref, ":")
+	if separatorPos <= 0 {
+		return MigrationRef{}, ErrManifestDependency
+	}
+	idx, err := strconv.Atoi(ref[separatorPos+1:])
+	if err != nil || idx < 0 {
+		return MigrationRef{}, ErrManifestDependency
+	}
+	return MigrationRef{Repo: Repo(ref[:separatorPos]), Idx: idx}, nil
+}
+
+type manifest struct {
+	Repos map[Repo][]manifestMigration `yaml:"repos" json:"repos"`
+}
+
+type manifestMigration struct {
+	Description   string   `yaml:"description" json:"description"`
+	Version       string   `yaml:"version" json:"version"`
+	Up            string   `yaml:"up" json:"up"`
+	Down          string   `yaml:"down" json:"down"`
+	UpSQL         string   `yaml:"upSql" json:"upSql"`
+	DownSQL       string   `yaml:"downSql" json:"downSql"`
+	NoTransaction bool     `yaml:"noTransaction" json:"noTransaction"`
+	Irreversible  bool     `yaml:"irreversible" json:"irreversible"`
+	DependsOn     []string `yaml:"dependsOn" json:"dependsOn"`
+	Tags          []string `yaml:"tags" json:"tags"`
+	Author        string   `yaml:"author" json:"author"`
+	Timeout       string   `yaml:"timeout" json:"timeout"`
+	LockTimeout   string   `yaml:"lockTimeout" json:"lockTimeout"`
+}
+
+// Errors reported by ReadManifest func. They are wrapped with repo and index of invalid migration
+// and collected in multierror, use errors.Is for checking them.
+var (
+	ErrManifestFormat          = errors.New(`manifest file must have ".yaml", ".yml" or ".json" extension`)
+	ErrManifestMissingUp       = errors.New("manifest migration must have up file or inline up SQL")
+	ErrManifestMissingDown     = errors.New("manifest migration must have down file or inline down SQL (unless irreversible)")
+	ErrManifestPathAndSQL      = errors.New("manifest migration must not have both file and inline SQL for the same direction")
+	ErrManifestDependency      = errors.New(`manifest migration must depend on listed migrations in form "repo:idx"`)
+	ErrManifestDependencyOrder = errors.New("manifest migration must not depend on itself or later migration of its repo")
+	ErrManifestDependencyCycle = errors.New("manifest migrations must not depend on each other in cycle")
+	ErrManifestTimeout         = errors.New(`manifest migration timeout and lockTimeout must be positive durations (eg. "30s")`)
+	ErrManifestVersionOrder    = errors.New("manifest migrations of repo must be listed in order of their versions")
+)
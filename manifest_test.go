@@ -0,0 +1,199 @@
+package dbmigrat
+
+import (
+	"errors"
+	"github.com/hashicorp/go-multierror"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestReadManifest(t *testing.T) {
+	fileSys := fstest.MapFS{
+		"db/auth/0.up.sql":   {Data: []byte("create table users (id serial primary key);")},
+		"db/auth/0.down.sql": {Data: []byte("drop table users;")},
+		"db/migrations.yaml": {Data: []byte(`repos:
+  auth:
+    - description: create users table
+      up: auth/0.up.sql
+      down: auth/0.down.sql
+      author: john
+      tags: [users]
+    - description: add username index
+      upSql: |
+        -- +dbmigrat NoTransaction
+        create index concurrently users_username_idx on users (username);
+      downSql: drop index users_username_idx;
+  billing:
+    - description: create orders table
+      version: "20211018143000"
+      upSql: create table orders (id serial primary key, user_id integer references users (id));
+      irreversible: true
+      dependsOn: ["auth:0"]
+      timeout: 30s
+      lockTimeout: 5s
+`)},
+		"db/migrations.json": {Data: []byte(`{"repos": {"auth": [
+  {"description": "create users table", "up": "auth/0.up.sql", "down": "auth/0.down.sql", "author": "john", "tags": ["users"]},
+  {"description": "add username index", "upSql": "-- +dbmigrat NoTransaction\ncreate index concurrently users_username_idx on users (username);\n", "downSql": "drop index users_username_idx;"}
+], "billing": [
+  {"description": "create orders table", "version": "20211018143000", "upSql": "create table orders (id serial primary key, user_id integer references users (id));",
+   "irreversible": true, "dependsOn": ["auth:0"], "timeout": "30s", "lockTimeout": "5s"}
+]}}`)},
+	}
+	expected := Migrations{
+		"auth": {
+			{
+				Description: "create users table",
+				Up:          "create table users (id serial primary key);",
+				Down:        "drop table users;",
+				Author:      "john",
+				Tags:        []string{"users"},
+			},
+			{
+				Description:   "add username index",
+				Up:            "-- +dbmigrat NoTransaction\ncreate index concurrently users_username_idx on users (username);\n",
+				Down:          "drop index users_username_idx;",
+				NoTransaction: true,
+			},
+		},
+		"billing": {
+			{
+				Description:      "create orders table",
+				Version:          "20211018143000",
+				Up:               "create table orders (id serial primary key, user_id integer references users (id));",
+				Irreversible:     true,
+				DependsOn:        []MigrationRef{{Repo: "auth", Idx: 0}},
+				StatementTimeout: 30 * time.Second,
+				LockTimeout:      5 * time.Second,
+			},
+		},
+	}
+
+	t.Run("properly reads yaml manifest", func(t *testing.T) {
+		migrations, err := ReadManifest(fileSys, "db/migrations.yaml")
+		assert.NoError(t, err)
+		assert.Equal(t, expected, migrations)
+	})
+	t.Run("properly reads json manifest", func(t *testing.T) {
+		migrations, err := ReadManifest(fileSys, "db/migrations.json")
+		assert.NoError(t, err)
+		assert.Equal(t, expected, migrations)
+	})
+	t.Run("returns error for unknown format", func(t *testing.T) {
+		fileSys := fstest.MapFS{"migrations.toml": {}}
+		migrations, err := ReadManifest(fileSys, "migrations.toml")
+		assert.EqualError(t, err, ErrManifestFormat.Error())
+		assert.Nil(t, migrations)
+	})
+	t.Run("returns error for unknown field", func(t *testing.T) {
+		fileSys := fstest.MapFS{"migrations.yml": {Data: []byte("repos:\n  auth:\n    - descr: typo\n")}}
+		migrations, err := ReadManifest(fileSys, "migrations.yml")
+		assert.Error(t, err)
+		assert.Nil(t, migrations)
+	})
+	t.Run("returns all errors", func(t *testing.T) {
+		fileSys := fstest.MapFS{
+			"migrations.yaml": {Data: []byte(`repos:
+  auth:
+    - description: missing up
+      downSql: drop table users;
+    - description: missing down
+      upSql: create table users (id serial primary key);
+    - description: path and inline
+      up: 2.up.sql
+      upSql: create table users (id serial primary key);
+      downSql: drop table users;
+    - description: missing file
+      up: 3.up.sql
+      downSql: drop table users;
+  billing:
+    - description: unknown dependency
+      upSql: create table orders (id serial primary key);
+      downSql: drop table orders;
+      dependsOn: ["auth:4"]
+    - description: invalid timeout
+      upSql: create table orders (id serial primary key);
+      downSql: drop table orders;
+      timeout: soon
+    - description: unknown directive
+      upSql: "-- +dbmigrat Transactional\ncreate table orders (id serial primary key);"
+      downSql: drop table orders;
+  delivery:
+    - description: newer
+      version: "20211020100000"
+      upSql: create table deliveries (id serial primary key);
+      downSql: drop table deliveries;
+    - description: older
+      version: "20211018143000"
+      upSql: create table addresses (id serial primary key);
+      downSql: drop table addresses;
+`)},
+			"2.up.sql": {},
+		}
+		migrations, err := ReadManifest(fileSys, "migrations.yaml")
+		assert.Nil(t, migrations)
+		assert.True(t, errors.Is(err, ErrManifestMissingUp))
+		assert.True(t, errors.Is(err, ErrUnknownDirective))
+		merr, ok := err.(*multierror.Error)
+		assert.True(t, ok)
+		assert.Len(t, merr.Errors, 8)
+		assert.EqualError(t, merr.Errors[0], errWithRepoIdx{inner: ErrManifestMissingUp, repo: "auth", idx: 0}.Error())
+		assert.EqualError(t, merr.Errors[1], errWithRepoIdx{inner: ErrManifestMissingDown, repo: "auth", idx: 1}.Error())
+		assert.EqualError(t, merr.Errors[2], errWithRepoIdx{inner: ErrManifestPathAndSQL, repo: "auth", idx: 2}.Error())
+		assert.Contains(t, merr.Errors[3].Error(), "3.up.sql")
+		assert.EqualError(t, merr.Errors[4], errWithRepoIdx{inner: ErrManifestDependency, repo: "billing", idx: 0}.Error())
+		assert.EqualError(t, merr.Errors[5], errWithRepoIdx{inner: ErrManifestTimeout, repo: "billing", idx: 1}.Error())
+		assert.EqualError(t, merr.Errors[6], errWithRepoIdx{inner: ErrUnknownDirective, repo: "billing", idx: 2}.Error())
+		assert.EqualError(t, merr.Errors[7], errWithRepoIdx{inner: ErrManifestVersionOrder, repo: "delivery", idx: 1}.Error())
+	})
+	t.Run("rejects dependency on itself or later migration of repo", func(t *testing.T) {
+		fileSys := fstest.MapFS{
+			"migrations.yaml": {Data: []byte(`repos:
+  auth:
+    - description: self dependency
+      upSql: create table users (id serial primary key);
+      downSql: drop table users;
+      dependsOn: ["auth:0"]
+    - description: later dependency
+      upSql: create table emails (id serial primary key);
+      downSql: drop table emails;
+      dependsOn: ["auth:2"]
+    - description: create sessions table
+      upSql: create table sessions (id serial primary key);
+      downSql: drop table sessions;
+`)},
+		}
+		migrations, err := ReadManifest(fileSys, "migrations.yaml")
+		assert.Nil(t, migrations)
+		merr, ok := err.(*multierror.Error)
+		assert.True(t, ok)
+		assert.Len(t, merr.Errors, 2)
+		assert.EqualError(t, merr.Errors[0], errWithRepoIdx{inner: ErrManifestDependencyOrder, repo: "auth", idx: 0}.Error())
+		assert.EqualError(t, merr.Errors[1], errWithRepoIdx{inner: ErrManifestDependencyOrder, repo: "auth", idx: 1}.Error())
+	})
+	t.Run("rejects dependency cycle", func(t *testing.T) {
+		fileSys := fstest.MapFS{
+			"migrations.yaml": {Data: []byte(`repos:
+  auth:
+    - description: create users table
+      upSql: create table users (id serial primary key);
+      downSql: drop table users;
+      dependsOn: ["billing:1"]
+  billing:
+    - description: create orders table
+      upSql: create table orders (id serial primary key);
+      downSql: drop table orders;
+    - description: create invoices table
+      upSql: create table invoices (id serial primary key);
+      downSql: drop table invoices;
+      dependsOn: ["auth:0"]
+`)},
+		}
+		migrations, err := ReadManifest(fileSys, "migrations.yaml")
+		assert.Nil(t, migrations)
+		assert.True(t, errors.Is(err, ErrManifestDependencyCycle))
+		assert.EqualError(t, err, multierror.Append(nil, errWithRepoIdx{inner: ErrManifestDependencyCycle, repo: "auth", idx: 0}).Error())
+	})
+}
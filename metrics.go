@@ -0,0 +1,230 @@
+package dbmigrat
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsCollector computes Metrics of migrations log and serves them in Prometheus text exposition format,
+// so it might be registered as scrape target directly (eg. http.Handle("/metrics/dbmigrat", collector)).
+// Computed Metrics are cached for DefaultMetricsCacheTTL (see WithCacheTTL option),
+// so frequent scrapes do not query database every time.
+type MetricsCollector struct {
+	s           store
+	migrations  Migrations
+	constLabels map[string]string
+	cacheTTL    time.Duration
+
+	mu          sync.Mutex
+	cached      *Metrics
+	collectedAt time.Time
+}
+
+// NewMetricsCollector creates collector comparing migrations log with passed migrations.
+// constLabels are added to every exposed metric (eg. {"database": "billing"}), they might be nil.
+// Their names must be valid Prometheus label names other than "repo" (ErrMetricsLabelName is returned otherwise).
+//
+// Collector queries database with its own copy of passed PostgresStore, so scrapes do not run inside
+// transaction of Migrate or Rollback called concurrently with the same store.
+func NewMetricsCollector(s store, migrations Migrations, constLabels map[string]string, opts ...MetricsOption) (*MetricsCollector, error) {
+	for name := range constLabels {
+		if !labelNameRegexp.MatchString(name) || strings.HasPrefix(name, "__") || name == "repo" {
+			return nil, fmt.Errorf("%w (%s)", ErrMetricsLabelName, name)
+		}
+	}
+	if pgStore, ok := s.(*PostgresStore); ok {
+		s = &PostgresStore{DB: pgStore.DB}
+	}
+	o := metricsOptions{cacheTTL: DefaultMetricsCacheTTL}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &MetricsCollector{s: s, migrations: migrations, constLabels: constLabels, cacheTTL: o.cacheTTL}, nil
+}
+
+// MetricsOption allows for customizing MetricsCollector.
+type MetricsOption func(*metricsOptions)
+
+// WithCacheTTL sets how long Metrics computed by MetricsCollector are reused. Zero disables caching.
+func WithCacheTTL(ttl time.Duration) MetricsOption {
+	return func(o *metricsOptions) {
+		o.cacheTTL = ttl
+	}
+}
+
+type metricsOptions struct {
+	cacheTTL time.Duration
+}
+
+// DefaultMetricsCacheTTL is default time for which MetricsCollector reuses computed Metrics.
+const DefaultMetricsCacheTTL = 10 * time.Second
+
+// ErrMetricsLabelName is returned by NewMetricsCollector for invalid name of const label.
+var ErrMetricsLabelName = errors.New(`metrics const label name must match [a-zA-Z_][a-zA-Z0-9_]*, must not start with "__" and must not be "repo"`)
+
+var labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Metrics describes state of migrations log. LastMigrationSerial is -1 when no migration has been applied yet.
+type Metrics struct {
+	LastMigrationSerial int
+	IntegrityOK         bool
+	Repos               []RepoMetrics
+}
+
+// RepoMetrics contains number of applied and pending migrations of Repo and time of the last applied one
+// (zero when none). Repos present in either migrations log or passed migrations are listed.
+type RepoMetrics struct {
+	Repo          Repo
+	Applied       int
+	Pending       int
+	LastAppliedAt time.Time
+}
+
+// Collect returns Metrics of migrations log. They are computed again when cached ones are older than cache TTL.
+func (mc *MetricsCollector) Collect() (*Metrics, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.cached == nil || time.Since(mc.collectedAt) >= mc.cacheTTL {
+		metrics, err := mc.collect()
+		if err != nil {
+			return nil, err
+		}
+		mc.cached, mc.collectedAt = metrics, time.Now()
+	}
+	metrics := *mc.cached
+	metrics.Repos = append([]RepoMetrics(nil), mc.cached.Repos...)
+	return &metrics, nil
+}
+
+func (mc *MetricsCollector) collect() (*Metrics, error) {
+	migrationLogs, err := mc.s.fetchAllMigrationLogs()
+	if err != nil {
+		return nil, err
+	}
+	integrity := checkLogIntegrity(migrationLogs, mc.migrations)
+
+	metrics := &Metrics{LastMigrationSerial: -1, IntegrityOK: !integrity.IsCorrupted}
+	byRepo := map[Repo]*RepoMetrics{}
+	applied := map[MigrationRef]bool{}
+	for repo := range mc.migrations {
+		byRepo[repo] = &RepoMetrics{Repo: repo}
+	}
+	for _, log := range migrationLogs {
+		repoMetrics, ok := byRepo[log.Repo]
+		if !ok {
+			repoMetrics = &RepoMetrics{Repo: log.Repo}
+			byRepo[log.Repo] = repoMetrics
+		}
+		repoMetrics.Applied++
+		if log.AppliedAt.After(repoMetrics.LastAppliedAt) {
+			repoMetrics.LastAppliedAt = log.AppliedAt
+		}
+		if log.MigrationSerial > metrics.LastMigrationSerial {
+			metrics.LastMigrationSerial = log.MigrationSerial
+		}
+		applied[MigrationRef{Repo: log.Repo, Idx: log.Idx}] = true
+	}
+
+	repos := make([]Repo, 0, len(byRepo))
+	for repo := range byRepo {
+		repos = append(repos, repo)
+	}
+	sortRepos(repos)
+	for _, repo := range repos {
+		repoMetrics := byRepo[repo]
+		for idx := range mc.migrations[repo] {
+			if !applied[MigrationRef{Repo: repo, Idx: idx}] {
+				repoMetrics.Pending++
+			}
+		}
+		metrics.Repos = append(metrics.Repos, *repoMetrics)
+	}
+
+	return metrics, nil
+}
+
+// WritePrometheus collects Metrics and writes them in Prometheus text exposition format.
+func (mc *MetricsCollector) WritePrometheus(w io.Writer) error {
+	metrics, err := mc.Collect()
+	if err != nil {
+		return err
+	}
+	buffered := bufio.NewWriter(w)
+	pw := promWriter{w: buffered, constLabels: mc.constLabels}
+
+	pw.header("dbmigrat_applied_migrations", "Number of migrations applied in repo.")
+	for _, repoMetrics := range metrics.Repos {
+		pw.sample("dbmigrat_applied_migrations", repoMetrics.Repo, int64(repoMetrics.Applied))
+	}
+	pw.header("dbmigrat_pending_migrations", "Number of passed migrations of repo which are not applied.")
+	for _, repoMetrics := range metrics.Repos {
+		pw.sample("dbmigrat_pending_migrations", repoMetrics.Repo, int64(repoMetrics.Pending))
+	}
+	pw.header("dbmigrat_last_applied_timestamp_seconds", "Unix time of the last applied migration of repo.")
+	for _, repoMetrics := range metrics.Repos {
+		if !repoMetrics.LastAppliedAt.IsZero() {
+			pw.sample("dbmigrat_last_applied_timestamp_seconds", repoMetrics.Repo, repoMetrics.LastAppliedAt.Unix())
+		}
+	}
+	pw.header("dbmigrat_last_migration_serial", "The last migration serial saved in migrations log (-1 when empty).")
+	pw.sample("dbmigrat_last_migration_serial", "", int64(metrics.LastMigrationSerial))
+	var integrityOK int64
+	if metrics.IntegrityOK {
+		integrityOK = 1
+	}
+	pw.header("dbmigrat_integrity_ok", "1 when migrations log is consistent with passed migrations, 0 otherwise.")
+	pw.sample("dbmigrat_integrity_ok", "", integrityOK)
+
+	return buffered.Flush()
+}
+
+// ServeHTTP responds with metrics in Prometheus text exposition format.
+func (mc *MetricsCollector) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	var body strings.Builder
+	err := mc.WritePrometheus(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	io.WriteString(w, body.String())
+}
+
+// promWriter writes gauges in Prometheus text exposition format.
+type promWriter struct {
+	w           io.Writer
+	constLabels map[string]string
+}
+
+func (pw promWriter) header(name, help string) {
+	fmt.Fprintf(pw.w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+// sample writes value of gauge, labeled with repo unless it is empty.
+func (pw promWriter) sample(name string, repo Repo, value int64) {
+	labels := make([]string, 0, len(pw.constLabels)+1)
+	for key, labelValue := range pw.constLabels {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, key, escapeLabelValue(labelValue)))
+	}
+	sort.Strings(labels)
+	if repo != "" {
+		labels = append(labels, fmt.Sprintf(`repo="%s"`, escapeLabelValue(string(repo))))
+	}
+	if len(labels) == 0 {
+		fmt.Fprintf(pw.w, "%s %d\n", name, value)
+		return
+	}
+	fmt.Fprintf(pw.w, "%s{%s} %d\n", name, strings.Join(labels, ","), value)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
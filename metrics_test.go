@@ -0,0 +1,110 @@
+package dbmigrat
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsCollector(t *testing.T) {
+	assert.NoError(t, th.resetDB())
+	assert.NoError(t, th.pgStore.CreateLogTable())
+	_, err := Migrate(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"})
+	assert.NoError(t, err)
+
+	collector, err := NewMetricsCollector(th.pgStore, th.migrations2, map[string]string{"database": `shop "main"`})
+	assert.NoError(t, err)
+	metrics, err := collector.Collect()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, metrics.LastMigrationSerial)
+	assert.True(t, metrics.IntegrityOK)
+	assert.Len(t, metrics.Repos, 3)
+	for i, expected := range []RepoMetrics{{Repo: "auth", Applied: 2}, {Repo: "billing", Applied: 1, Pending: 1}, {Repo: "delivery", Pending: 1}} {
+		assert.Equal(t, expected.Repo, metrics.Repos[i].Repo)
+		assert.Equal(t, expected.Applied, metrics.Repos[i].Applied)
+		assert.Equal(t, expected.Pending, metrics.Repos[i].Pending)
+		assert.Equal(t, expected.Applied == 0, metrics.Repos[i].LastAppliedAt.IsZero())
+	}
+
+	recorder := httptest.NewRecorder()
+	collector.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	body := recorder.Body.String()
+	for _, line := range []string{
+		"# TYPE dbmigrat_applied_migrations gauge",
+		`dbmigrat_applied_migrations{database="shop \"main\"",repo="auth"} 2`,
+		`dbmigrat_pending_migrations{database="shop \"main\"",repo="billing"} 1`,
+		`dbmigrat_pending_migrations{database="shop \"main\"",repo="delivery"} 1`,
+		`dbmigrat_last_migration_serial{database="shop \"main\""} 0`,
+		`dbmigrat_integrity_ok{database="shop \"main\""} 1`,
+	} {
+		assert.Contains(t, strings.Split(body, "\n"), line)
+	}
+	assert.Regexp(t, `dbmigrat_last_applied_timestamp_seconds\{database="shop \\"main\\"",repo="auth"\} \d+\n`, body)
+	assert.NotContains(t, body, `dbmigrat_last_applied_timestamp_seconds{database="shop \"main\"",repo="delivery"}`)
+
+	// # Scrape does not use transaction of store shared with Migrate
+	collector, err = NewMetricsCollector(th.pgStore, th.migrations2, nil, WithCacheTTL(0))
+	assert.NoError(t, err)
+	assert.NoError(t, th.pgStore.begin())
+	assert.NoError(t, th.pgStore.insertLogs([]MigrationLog{{Idx: 0, Repo: "delivery", MigrationSerial: 1, Checksum: sha1Checksum(th.migrations2["delivery"][0].Up)}}))
+	metrics, err = collector.Collect()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, metrics.LastMigrationSerial)
+	assert.NoError(t, th.pgStore.rollback())
+
+	// # Redundant repo breaks integrity
+	collector, err = NewMetricsCollector(th.pgStore, Migrations{"auth": th.migrations1["auth"]}, nil)
+	assert.NoError(t, err)
+	metrics, err = collector.Collect()
+	assert.NoError(t, err)
+	assert.False(t, metrics.IntegrityOK)
+
+	recorder = httptest.NewRecorder()
+	collector, err = NewMetricsCollector(errorStoreMock{wrapped: th.pgStore, errFetchAllMigrationLogs: true}, th.migrations1, nil)
+	assert.NoError(t, err)
+	collector.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+}
+
+func TestMetricsCollectorCache(t *testing.T) {
+	assert.NoError(t, th.resetDB())
+	assert.NoError(t, th.pgStore.CreateLogTable())
+	_, err := Migrate(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"})
+	assert.NoError(t, err)
+
+	cachedCollector, err := NewMetricsCollector(th.pgStore, th.migrations2, nil, WithCacheTTL(time.Hour))
+	assert.NoError(t, err)
+	uncachedCollector, err := NewMetricsCollector(th.pgStore, th.migrations2, nil, WithCacheTTL(0))
+	assert.NoError(t, err)
+	for _, collector := range []*MetricsCollector{cachedCollector, uncachedCollector} {
+		metrics, err := collector.Collect()
+		assert.NoError(t, err)
+		assert.Equal(t, 0, metrics.LastMigrationSerial)
+	}
+
+	_, err = Migrate(th.pgStore, th.migrations2, RepoOrder{"auth", "billing", "delivery"})
+	assert.NoError(t, err)
+	metrics, err := cachedCollector.Collect()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, metrics.LastMigrationSerial)
+	metrics, err = uncachedCollector.Collect()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, metrics.LastMigrationSerial)
+}
+
+func TestNewMetricsCollector(t *testing.T) {
+	for _, name := range []string{"", "1database", "data-base", "__name", "repo"} {
+		collector, err := NewMetricsCollector(nil, Migrations{}, map[string]string{name: "shop"})
+		assert.True(t, errors.Is(err, ErrMetricsLabelName), name)
+		assert.EqualError(t, err, ErrMetricsLabelName.Error()+" ("+name+")")
+		assert.Nil(t, collector)
+	}
+	collector, err := NewMetricsCollector(nil, Migrations{}, map[string]string{"database": "shop", "_shard2": "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultMetricsCacheTTL, collector.cacheTTL)
+}
@@ -0,0 +1,139 @@
+package dbmigrat
+
+import (
+	"context"
+	"time"
+)
+
+// Option allows for customizing behaviour of Migrate func and funcs modifying migrations log
+// (Rollback, FakeApply, FakeRollback, Repair, SetEnvironment). CheckLogTableIntegrity accepts WithLogger option.
+type Option func(*options)
+
+// WithGapFilling allows Migrate to run migrations missing in migrations log
+// but lower than the last applied index of the repo (eg. left by manual delete of log entry).
+// Without this option Migrate refuses to run on such log.
+func WithGapFilling() Option {
+	return func(o *options) {
+		o.fillGaps = true
+	}
+}
+
+// WithConfirmation confirms operation on database tagged with protected Environment.
+// Token must be equal to the environment name.
+func WithConfirmation(token string) Option {
+	return func(o *options) {
+		o.confirmation = token
+	}
+}
+
+// WithHooks makes Migrate and Rollback call hooks around the run and every migration.
+func WithHooks(hooks Hooks) Option {
+	return func(o *options) {
+		o.hooks = hooks
+	}
+}
+
+// WithLogger makes Migrate, Rollback and CheckLogTableIntegrity emit structured events to logger
+// (eg. *slog.Logger). Without this option dbmigrat is silent.
+func WithLogger(logger Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithTracer makes Migrate and Rollback start spans with tracer (see Tracer).
+// Span of the run is child of span carried by ctx.
+func WithTracer(ctx context.Context, tracer Tracer) Option {
+	return func(o *options) {
+		o.traceCtx = ctx
+		o.tracer = tracer
+	}
+}
+
+func newOptions(opts []Option) options {
+	result := options{logger: nopLogger{}, tracer: nopTracer{}, traceCtx: context.Background()}
+	for _, opt := range opts {
+		opt(&result)
+	}
+	return result
+}
+
+type options struct {
+	fillGaps     bool
+	confirmation string
+	hooks        Hooks
+	logger       Logger
+	tracer       Tracer
+	traceCtx     context.Context
+}
+
+// ReadOption allows for customizing behaviour of ReadDir and ReadRepos funcs.
+type ReadOption func(*readOptions)
+
+// Recursive allows ReadDir to collect migration files from subdirectories.
+func Recursive() ReadOption {
+	return func(o *readOptions) {
+		o.recursive = true
+	}
+}
+
+// Versioned makes ReadDir accept sortable versions (eg. timestamps) instead of sequential indexes
+// as the first part of files names. It helps to avoid conflicts when migrations are added in parallel.
+func Versioned() ReadOption {
+	return func(o *readOptions) {
+		o.versioned = true
+	}
+}
+
+func newReadOptions(opts []ReadOption) readOptions {
+	var result readOptions
+	for _, opt := range opts {
+		opt(&result)
+	}
+	return result
+}
+
+type readOptions struct {
+	recursive bool
+	versioned bool
+}
+
+// NewOption allows for customizing files created by NewMigration func.
+type NewOption func(*scaffoldOptions)
+
+// SingleFile makes NewMigration create single file migration with up and down sections.
+func SingleFile() NewOption {
+	return func(o *scaffoldOptions) {
+		o.singleFile = true
+	}
+}
+
+// VersionedAt makes NewMigration prefix files names with time formatted as version (eg. 20211018143000)
+// instead of index. Such files should be read by ReadDir with Versioned option.
+func VersionedAt(t time.Time) NewOption {
+	return func(o *scaffoldOptions) {
+		o.version = t.UTC().Format("20060102150405")
+	}
+}
+
+// RecursiveIdx makes NewMigration look for the highest index in subdirectories too.
+// It should be passed for repos read by ReadDir with Recursive option.
+func RecursiveIdx() NewOption {
+	return func(o *scaffoldOptions) {
+		o.recursive = true
+	}
+}
+
+func newScaffoldOptions(opts []NewOption) scaffoldOptions {
+	var result scaffoldOptions
+	for _, opt := range opts {
+		opt(&result)
+	}
+	return result
+}
+
+type scaffoldOptions struct {
+	singleFile bool
+	version    string
+	recursive  bool
+}
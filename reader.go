@@ -3,7 +3,9 @@ package dbmigrat
 import (
 	"errors"
 	"fmt"
+	"github.com/hashicorp/go-multierror"
 	"io/fs"
+	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -14,108 +16,455 @@ import (
 // Directory under provided path must contain files only.
 // Files names must follow convention: a.b.c
 // where a is incrementing int (0,1,2,3,..), b is description, c is direction - "up" or "down".
+// Direction might be followed by extension (eg. ".sql").
+// Description might contain dots, hyphens and spaces - direction is the last "up" or "down" part of file name.
 // Every migration must have corresponding up and down file.
 // Up and down file for same migration must have same description.
 //
+// Alternatively, migration might be kept in single file named a.b.sql (without direction),
+// containing sections started by "-- +dbmigrat Up" and "-- +dbmigrat Down" lines.
+// Both formats accept "-- +dbmigrat NoTransaction" directive (see Migration.NoTransaction)
+// and "-- +dbmigrat Irreversible" directive (see Migration.Irreversible).
+// Timeouts are set with "-- +dbmigrat StatementTimeout 30s" and "-- +dbmigrat LockTimeout 5s" directives
+// (see Migration.StatementTimeout and Migration.LockTimeout).
+// Irreversible migration might have no down file (or no down section).
+//
+// Content of up and down files is taken as it is, while sections of single file are trimmed
+// and directive lines are dropped from them. Checksum saved in migrations log is computed from Migration.Up,
+// so converting migration between formats changes its checksum. CheckLogTableIntegrity reports such
+// migration in IntegrityCheckResult.InvalidChecksums, it should be fixed with Repair func.
+//
 // Examples of valid files names:
+//
 //	0.create_users_table.up
 //	0.create_users_table.down.sql
 //	1.add_username_column.up
 //	1.add_username_column.down.sql
-func ReadDir(fileSys fs.FS, path string) ([]Migration, error) {
-	dirEntries, err := fs.ReadDir(fileSys, path)
+//	2.add v2.1 columns.up.sql
+//	2.add v2.1 columns.down.sql
+//	3.add_username_index.sql
+//
+// When Versioned option is passed, files names must follow convention: v_b.c (or v.b.c)
+// where v is sortable version (eg. timestamp 20211018143000) without "_" and "." characters.
+// Versions are compared as strings, so they should have equal length.
+// Migrations are ordered by version, Migration.Version is set.
+//
+// When Recursive option is passed, directory might contain subdirectories (eg. grouping migrations by year).
+// Files from the whole tree are ordered by index, file names in returned errors are relative to path.
+func ReadDir(fileSys fs.FS, path string, opts ...ReadOption) ([]Migration, error) {
+	options := newReadOptions(opts)
+	fileNames, dirNames, err := listFiles(fileSys, path, options.recursive)
 	if err != nil {
 		return nil, err
 	}
-
-	var fileNames []string
-	for _, dirEntry := range dirEntries {
-		if dirEntry.IsDir() {
-			return nil, errContainsDirectory
-		}
-		fileNames = append(fileNames, dirEntry.Name())
+	var errs *multierror.Error
+	for _, dirName := range dirNames {
+		errs = multierror.Append(errs, errWithFileName{inner: ErrContainsDirectory, fileName: dirName})
 	}
 
-	parsedFN, err := parseFileNames(fileNames)
+	parseFN := parseFileNames
+	if options.versioned {
+		parseFN = parseVersionedFileNames
+	}
+	parsedFN, err := parseFN(fileNames)
 	if err != nil {
-		return nil, err
+		errs = multierror.Append(errs, err)
 	}
 	var result []Migration
-	for i := 0; i+1 < len(parsedFN); i += 2 {
-		if parsedFN[i].idx != i/2 || parsedFN[i+1].idx != i/2 {
-			return nil, errWithFileName{inner: errNotSequential, fileName: parsedFN[i].fileName}
+	expectedIdx := 0
+	for i := 0; i < len(parsedFN); {
+		j := i + 1
+		for j < len(parsedFN) && parsedFN[j].idx == parsedFN[i].idx {
+			j++
+		}
+		group := parsedFN[i:j]
+		i = j
+
+		sequential := group[0].idx == expectedIdx
+		expectedIdx = group[0].idx + 1
+		if !sequential {
+			errs = multierror.Append(errs, errWithFileName{inner: ErrNotSequential, fileName: group[0].fileName})
 		}
-		if parsedFN[i].description != parsedFN[i+1].description {
-			return nil, errWithFileName{inner: errDescriptionNotEqual, fileName: parsedFN[i].fileName}
+		if len(group) == 1 && group[0].direction == up {
+			migration, err := readMigration(fileSys, path, group)
+			if _, ok := err.(errWithFileName); ok {
+				errs = multierror.Append(errs, err)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if migration.Irreversible {
+				result = append(result, *migration)
+				continue
+			}
 		}
-		if parsedFN[i].direction == parsedFN[i+1].direction {
-			return nil, errWithFileName{inner: errSameDirections, fileName: parsedFN[i].fileName}
+		if len(group) == 1 && group[0].direction != both {
+			// last migration with missing direction file is skipped
+			if sequential && i == len(parsedFN) {
+				break
+			}
+			if sequential {
+				errs = multierror.Append(errs, errWithFileName{inner: ErrNotSequential, fileName: group[0].fileName})
+			}
+			continue
 		}
-		iData, err := fs.ReadFile(fileSys, filepath.Join(path, parsedFN[i].fileName))
+		err = validateGroup(group)
 		if err != nil {
-			return nil, err
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		migration, err := readMigration(fileSys, path, group)
+		if _, ok := err.(errWithFileName); ok {
+			errs = multierror.Append(errs, err)
+			continue
 		}
-		iPlus1Data, err := fs.ReadFile(fileSys, filepath.Join(path, parsedFN[i+1].fileName))
 		if err != nil {
 			return nil, err
 		}
-		result = append(result, Migration{
-			Description: parsedFN[i].description,
-			Up:          string(iData),
-			Down:        string(iPlus1Data),
-		})
+		result = append(result, *migration)
+	}
+	if errs != nil {
+		return nil, errs
 	}
 
 	return result, nil
 }
 
+// validateGroup checks files sharing the same index. Group must consist of
+// single file migration or pair of up and down files with equal descriptions.
+// Any other files sharing the index (eg. two up files from different directories in recursive mode)
+// are reported as ErrDuplicatedIdx listing all of them.
+func validateGroup(group parsedFileNames) error {
+	if len(group) == 1 && group[0].direction == both {
+		return nil
+	}
+	if len(group) > 2 || group[0].direction == both || group[1].direction == both || group[0].direction == group[1].direction {
+		return errWithFileName{inner: ErrDuplicatedIdx, fileName: group.fileNames()}
+	}
+	if group[0].description != group[1].description {
+		return errWithFileName{inner: ErrDescriptionNotEqual, fileName: group[0].fileName}
+	}
+	return nil
+}
+
+// readMigration reads files of valid group. Invalid content of file is reported as errWithFileName.
+func readMigration(fileSys fs.FS, dirPath string, group parsedFileNames) (*Migration, error) {
+	if group[0].direction == both {
+		data, err := fs.ReadFile(fileSys, filepath.Join(dirPath, group[0].fileName))
+		if err != nil {
+			return nil, err
+		}
+		migration, err := parseSingleFile(string(data))
+		if err != nil {
+			return nil, errWithFileName{inner: err, fileName: group[0].fileName}
+		}
+		migration.Description = group[0].description
+		migration.Version = group[0].version
+		return migration, nil
+	}
+
+	upData, err := fs.ReadFile(fileSys, filepath.Join(dirPath, group[0].fileName))
+	if err != nil {
+		return nil, err
+	}
+	migration := Migration{
+		Description: group[0].description,
+		Version:     group[0].version,
+		Up:          string(upData),
+	}
+	err = parseDirectives(migration.Up, &migration)
+	if err != nil {
+		return nil, errWithFileName{inner: err, fileName: group[0].fileName}
+	}
+	// irreversible migration might have no down file
+	if len(group) == 1 {
+		return &migration, nil
+	}
+
+	downData, err := fs.ReadFile(fileSys, filepath.Join(dirPath, group[1].fileName))
+	if err != nil {
+		return nil, err
+	}
+	migration.Down = string(downData)
+	err = parseDirectives(migration.Down, &migration)
+	if err != nil {
+		return nil, errWithFileName{inner: err, fileName: group[1].fileName}
+	}
+	return &migration, nil
+}
+
+// ReadRepos is helper func which allows for reading migrations of several repos from single file system.
+// Every directory named "migrations" under root becomes repo named after path of its parent
+// directory relative to root (eg. "auth/migrations" becomes repo "auth").
+// When there is no such directory, every direct subdirectory of root becomes repo named after it.
+// Migrations of every repo are read by ReadDir func (with passed opts), errors of all repos are collected.
+//
+// Optionally, root might contain "repo_order" file listing repos names (one per line)
+// in order in which they should be migrated. Blank lines and lines starting with "#" are ignored.
+// When the file exists, it must list every found repo exactly once. Returned RepoOrder is nil otherwise.
+func ReadRepos(fileSys fs.FS, root string, opts ...ReadOption) (Migrations, RepoOrder, error) {
+	repoDirs := map[Repo]string{}
+	err := fs.WalkDir(fileSys, root, func(dirPath string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if dirEntry.IsDir() && dirPath != root && path.Dir(dirPath) != root && dirEntry.Name() == migrationsDirName {
+			repoDirs[Repo(relativePath(root, path.Dir(dirPath)))] = dirPath
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(repoDirs) == 0 {
+		dirEntries, err := fs.ReadDir(fileSys, root)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, dirEntry := range dirEntries {
+			if dirEntry.IsDir() {
+				repoDirs[Repo(dirEntry.Name())] = path.Join(root, dirEntry.Name())
+			}
+		}
+	}
+
+	repos := make([]Repo, 0, len(repoDirs))
+	for repo := range repoDirs {
+		repos = append(repos, repo)
+	}
+	sortRepos(repos)
+	migrations := Migrations{}
+	var errs *multierror.Error
+	for _, repo := range repos {
+		repoMigrations, err := ReadDir(fileSys, repoDirs[repo], opts...)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		migrations[repo] = repoMigrations
+	}
+	if errs != nil {
+		return nil, nil, errs
+	}
+
+	repoOrder, err := readRepoOrder(fileSys, root, migrations)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return migrations, repoOrder, nil
+}
+
+func readRepoOrder(fileSys fs.FS, root string, migrations Migrations) (RepoOrder, error) {
+	data, err := fs.ReadFile(fileSys, path.Join(root, repoOrderFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var repoOrder RepoOrder
+	listed := map[Repo]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		repo := Repo(line)
+		if _, ok := migrations[repo]; !ok {
+			return nil, errWithRepo{inner: ErrRepoOrderUnknownRepo, repo: repo}
+		}
+		if listed[repo] {
+			return nil, errWithRepo{inner: ErrRepoOrderDuplicatedRepo, repo: repo}
+		}
+		listed[repo] = true
+		repoOrder = append(repoOrder, repo)
+	}
+	for repo := range migrations {
+		if !listed[repo] {
+			return nil, errWithRepo{inner: ErrRepoOrderMissingRepo, repo: repo}
+		}
+	}
+
+	return repoOrder, nil
+}
+
+func relativePath(root, dirPath string) string {
+	if root == "." {
+		return dirPath
+	}
+	return strings.TrimPrefix(dirPath, root+"/")
+}
+
+// listFiles returns names of files under dirPath. When recursive is false,
+// names of found subdirectories are returned as second value.
+func listFiles(fileSys fs.FS, dirPath string, recursive bool) ([]string, []string, error) {
+	if !recursive {
+		dirEntries, err := fs.ReadDir(fileSys, dirPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		var fileNames, dirNames []string
+		for _, dirEntry := range dirEntries {
+			if dirEntry.IsDir() {
+				dirNames = append(dirNames, dirEntry.Name())
+				continue
+			}
+			fileNames = append(fileNames, dirEntry.Name())
+		}
+		return fileNames, dirNames, nil
+	}
+
+	var fileNames []string
+	err := fs.WalkDir(fileSys, dirPath, func(filePath string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !dirEntry.IsDir() {
+			fileNames = append(fileNames, relativePath(dirPath, filePath))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return fileNames, nil, nil
+}
+
+// parseFileNames returns sorted valid files names. Invalid ones are reported in returned multierror.
 func parseFileNames(fileNames []string) (parsedFileNames, error) {
 	var parsedFN parsedFileNames
+	var errs *multierror.Error
 	for _, fileName := range fileNames {
 		parsed, err := parseFileName(fileName)
 		if err != nil {
-			return nil, err
+			errs = multierror.Append(errs, errWithFileName{inner: err, fileName: fileName})
+			continue
 		}
 		parsedFN = append(parsedFN, parsed)
 	}
 	sort.Sort(parsedFN)
-	return parsedFN, nil
+	return parsedFN, errs.ErrorOrNil()
+}
+
+// parseVersionedFileNames parses files names prefixed with version instead of index.
+// Index of every file is position of its version among sorted versions.
+func parseVersionedFileNames(fileNames []string) (parsedFileNames, error) {
+	var parsedFN parsedFileNames
+	var errs *multierror.Error
+	var versions []string
+	seenVersions := map[string]bool{}
+	for _, fileName := range fileNames {
+		parsed, err := parseVersionedFileName(fileName)
+		if err != nil {
+			errs = multierror.Append(errs, errWithFileName{inner: err, fileName: fileName})
+			continue
+		}
+		parsedFN = append(parsedFN, parsed)
+		if !seenVersions[parsed.version] {
+			seenVersions[parsed.version] = true
+			versions = append(versions, parsed.version)
+		}
+	}
+	sort.Strings(versions)
+	versionToIdx := make(map[string]int, len(versions))
+	for idx, version := range versions {
+		versionToIdx[version] = idx
+	}
+	for _, parsed := range parsedFN {
+		parsed.idx = versionToIdx[parsed.version]
+	}
+	sort.Sort(parsedFN)
+	return parsedFN, errs.ErrorOrNil()
 }
 
 func parseFileName(fileName string) (*parsedFileName, error) {
-	divided := strings.Split(fileName, ".")
+	divided := strings.Split(path.Base(fileName), ".")
 	if len(divided) < 3 {
-		return nil, errFileNameParts
+		return nil, ErrFileNameParts
 	}
 	idx, err := strconv.Atoi(divided[0])
 	if err != nil {
-		return nil, errFileNameIdx
+		return nil, ErrFileNameIdx
 	}
-	if divided[2] != string(up) && divided[2] != string(down) {
-		return nil, errFileNameDirection
+	description, direction, err := parseDescriptionAndDirection(divided[1:])
+	if err != nil {
+		return nil, err
 	}
 	return &parsedFileName{
 		fileName:    fileName,
 		idx:         idx,
-		description: divided[1],
-		direction:   direction(divided[2]),
+		description: description,
+		direction:   direction,
+	}, nil
+}
+
+func parseVersionedFileName(fileName string) (*parsedFileName, error) {
+	base := path.Base(fileName)
+	separatorPos := strings.IndexAny(base, "_.")
+	if separatorPos <= 0 {
+		return nil, ErrFileNameVersion
+	}
+	divided := strings.Split(base[separatorPos+1:], ".")
+	if len(divided) < 2 {
+		return nil, ErrFileNameParts
+	}
+	description, direction, err := parseDescriptionAndDirection(divided)
+	if err != nil {
+		return nil, err
+	}
+	return &parsedFileName{
+		fileName:    fileName,
+		version:     base[:separatorPos],
+		description: description,
+		direction:   direction,
 	}, nil
 }
 
+// parseDescriptionAndDirection parses part of file name following index (or version).
+// Direction is the last "up" or "down" part, single file migration has no direction but ".sql" extension.
+func parseDescriptionAndDirection(divided []string) (string, direction, error) {
+	for i := len(divided) - 1; i >= 1; i-- {
+		if divided[i] == string(up) || divided[i] == string(down) {
+			return strings.Join(divided[:i], "."), direction(divided[i]), nil
+		}
+	}
+	if divided[len(divided)-1] == singleFileExt {
+		return strings.Join(divided[:len(divided)-1], "."), both, nil
+	}
+	return "", "", ErrFileNameDirection
+}
+
 func (a parsedFileNames) Len() int      { return len(a) }
 func (a parsedFileNames) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
 func (a parsedFileNames) Less(i, j int) bool {
-	if a[i].idx == a[j].idx {
-		return a[i].direction == up
+	if a[i].idx != a[j].idx {
+		return a[i].idx < a[j].idx
 	}
-	return a[i].idx < a[j].idx
+	// up file goes first, so group of valid migration starts with it
+	if a[i].direction != a[j].direction {
+		return directionOrder[a[i].direction] < directionOrder[a[j].direction]
+	}
+	return a[i].fileName < a[j].fileName
 }
 
 type parsedFileNames []*parsedFileName
 
+func (a parsedFileNames) fileNames() string {
+	fileNames := make([]string, len(a))
+	for i, parsed := range a {
+		fileNames[i] = parsed.fileName
+	}
+	return strings.Join(fileNames, ", ")
+}
+
 type parsedFileName struct {
 	fileName    string
 	idx         int
+	version     string
 	description string
 	direction   direction
 }
@@ -123,25 +472,59 @@ type parsedFileName struct {
 const (
 	up   direction = "up"
 	down direction = "down"
+	// both is direction of single file migration containing up and down sections
+	both direction = "both"
+)
+
+var directionOrder = map[direction]int{up: 0, down: 1, both: 2}
+
+const (
+	singleFileExt     = "sql"
+	migrationsDirName = "migrations"
+	repoOrderFileName = "repo_order"
 )
 
 type direction string
 
+// Errors reported by ReadDir and ReadRepos funcs. They are wrapped with name of invalid file (or repo)
+// and collected in multierror, use errors.Is for checking them.
 var (
-	errFileNameParts       = errors.New("migration's file name must contain at least 3 parts (idx.description.direction)")
-	errFileNameIdx         = errors.New("first part of migration's file name must be int")
-	errFileNameDirection   = errors.New(`third part of migration's file name must be "up" or "down" (case sensitive)`)
-	errContainsDirectory   = errors.New("migrations directory should contain files only")
-	errNotSequential       = errors.New("index in file name is not sequential (every migration has up and down file?)")
-	errDescriptionNotEqual = errors.New("descriptions for migration differs")
-	errSameDirections      = errors.New("migration must have up and down files")
+	ErrFileNameParts       = errors.New("migration's file name must contain at least 3 parts (idx.description.direction)")
+	ErrFileNameIdx         = errors.New("first part of migration's file name must be int")
+	ErrFileNameVersion     = errors.New(`migration's file name must start with version followed by "_" or "."`)
+	ErrFileNameDirection   = errors.New(`migration's file name must contain direction part after description - "up" or "down" (case sensitive)`)
+	ErrContainsDirectory   = errors.New("migrations directory should contain files only")
+	ErrNotSequential       = errors.New("index in file name is not sequential (every migration has up and down file?)")
+	ErrDescriptionNotEqual = errors.New("descriptions for migration differs")
+	ErrDuplicatedIdx       = errors.New("index in file name is used by more than one migration")
+
+	ErrRepoOrderUnknownRepo    = errors.New("repo_order file lists repo without migrations directory")
+	ErrRepoOrderDuplicatedRepo = errors.New("repo_order file lists repo more than once")
+	ErrRepoOrderMissingRepo    = errors.New("repo_order file does not list repo")
 )
 
 func (e errWithFileName) Error() string {
 	return fmt.Sprintf("%s (%s)", e.inner.Error(), e.fileName)
 }
 
+func (e errWithFileName) Unwrap() error {
+	return e.inner
+}
+
 type errWithFileName struct {
 	inner    error
 	fileName string
 }
+
+func (e errWithRepo) Error() string {
+	return fmt.Sprintf("%s (%s)", e.inner.Error(), e.repo)
+}
+
+func (e errWithRepo) Unwrap() error {
+	return e.inner
+}
+
+type errWithRepo struct {
+	inner error
+	repo  Repo
+}
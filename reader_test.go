@@ -3,6 +3,7 @@ package dbmigrat
 import (
 	"embed"
 	"errors"
+	"github.com/hashicorp/go-multierror"
 	"github.com/stretchr/testify/assert"
 	"io/fs"
 	"os"
@@ -58,7 +59,7 @@ func TestReadDir(t *testing.T) {
 			"contains_dir/dir": {Mode: os.ModeDir},
 		}
 		migrations, err := ReadDir(fileSys, "contains_dir")
-		assert.EqualError(t, err, errContainsDirectory.Error())
+		assert.EqualError(t, err, multierror.Append(nil, errWithFileName{inner: ErrContainsDirectory, fileName: "dir"}).Error())
 		assert.Equal(t, []Migration(nil), migrations)
 	})
 	t.Run("skips last migration with missing direction", func(t *testing.T) {
@@ -80,7 +81,7 @@ func TestReadDir(t *testing.T) {
 			"2.description.down": {},
 		}
 		migrations, err := ReadDir(fileSys, ".")
-		assert.EqualError(t, err, errWithFileName{inner: errNotSequential, fileName: "1.description.up"}.Error())
+		assert.EqualError(t, err, multierror.Append(nil, errWithFileName{inner: ErrNotSequential, fileName: "1.description.up"}).Error())
 		assert.Equal(t, []Migration(nil), migrations)
 	})
 	t.Run("returns error when files' indexes are not incrementing by one sequence", func(t *testing.T) {
@@ -91,7 +92,7 @@ func TestReadDir(t *testing.T) {
 			"2.description.down": {},
 		}
 		migrations, err := ReadDir(fileSys, ".")
-		assert.EqualError(t, err, errWithFileName{inner: errNotSequential, fileName: "2.description.up"}.Error())
+		assert.EqualError(t, err, multierror.Append(nil, errWithFileName{inner: ErrNotSequential, fileName: "2.description.up"}).Error())
 		assert.Equal(t, []Migration(nil), migrations)
 	})
 	t.Run("returns error when files' descriptions are not equal", func(t *testing.T) {
@@ -102,7 +103,7 @@ func TestReadDir(t *testing.T) {
 			"1.description_not_equal.down": {},
 		}
 		migrations, err := ReadDir(fileSys, ".")
-		assert.EqualError(t, err, errWithFileName{inner: errDescriptionNotEqual, fileName: "1.description.up"}.Error())
+		assert.EqualError(t, err, multierror.Append(nil, errWithFileName{inner: ErrDescriptionNotEqual, fileName: "1.description.up"}).Error())
 		assert.Equal(t, []Migration(nil), migrations)
 	})
 	t.Run("returns error when migration files have same direction", func(t *testing.T) {
@@ -115,9 +116,188 @@ func TestReadDir(t *testing.T) {
 			"2.description.down":   {},
 		}
 		migrations, err := ReadDir(fileSys, ".")
-		assert.EqualError(t, err, errWithFileName{inner: errSameDirections, fileName: "1.description.up.sql"}.Error())
+		assert.EqualError(t, err, multierror.Append(nil, errWithFileName{inner: ErrDuplicatedIdx, fileName: "1.description.up, 1.description.up.sql"}).Error())
 		assert.Equal(t, []Migration(nil), migrations)
 	})
+	t.Run("reads descriptions containing dots", func(t *testing.T) {
+		fileSys := fstest.MapFS{
+			"0.add_v2.1_columns.up.sql":   {Data: []byte("up")},
+			"0.add_v2.1_columns.down.sql": {Data: []byte("down")},
+		}
+		migrations, err := ReadDir(fileSys, ".")
+		assert.NoError(t, err)
+		assert.Equal(t, []Migration{{Description: "add_v2.1_columns", Up: "up", Down: "down"}}, migrations)
+	})
+	t.Run("reads single file migrations", func(t *testing.T) {
+		fileSys := fstest.MapFS{
+			"0.create_user_table.up.sql":   {Data: []byte("create table users (id serial primary key);")},
+			"0.create_user_table.down.sql": {Data: []byte("drop table users;")},
+			"1.add_username_column.sql": {Data: []byte(`-- +dbmigrat Up
+alter table users add column username varchar(32);
+-- +dbmigrat Down
+alter table users drop column username;
+`)},
+		}
+		migrations, err := ReadDir(fileSys, ".")
+		assert.NoError(t, err)
+		assert.Equal(t, expected, migrations)
+	})
+	t.Run("migration converted to single file has different checksum", func(t *testing.T) {
+		twoFiles, err := ReadDir(fstest.MapFS{
+			"0.add_username_index.up.sql":   {Data: []byte("-- +dbmigrat NoTransaction\ncreate index concurrently users_username_idx on users (username);\n")},
+			"0.add_username_index.down.sql": {Data: []byte("drop index concurrently users_username_idx;\n")},
+		}, ".")
+		assert.NoError(t, err)
+		singleFile, err := ReadDir(fstest.MapFS{
+			"0.add_username_index.sql": {Data: []byte(`-- +dbmigrat NoTransaction
+-- +dbmigrat Up
+create index concurrently users_username_idx on users (username);
+-- +dbmigrat Down
+drop index concurrently users_username_idx;
+`)},
+		}, ".")
+		assert.NoError(t, err)
+
+		assert.Equal(t, twoFiles[0].NoTransaction, singleFile[0].NoTransaction)
+		assert.Equal(t, "create index concurrently users_username_idx on users (username);", singleFile[0].Up)
+		assert.NotEqual(t, sha1Checksum(twoFiles[0].Up), sha1Checksum(singleFile[0].Up))
+	})
+	t.Run("returns error when single file migration is invalid", func(t *testing.T) {
+		fileSys := fstest.MapFS{
+			"0.description.sql": {Data: []byte("-- +dbmigrat Up\n")},
+		}
+		migrations, err := ReadDir(fileSys, ".")
+		assert.EqualError(t, err, multierror.Append(nil, errWithFileName{inner: ErrMissingSection, fileName: "0.description.sql"}).Error())
+		assert.Equal(t, []Migration(nil), migrations)
+	})
+	t.Run("returns error when single file and up/down files have same index", func(t *testing.T) {
+		fileSys := fstest.MapFS{
+			"0.description.sql":  {},
+			"0.description.up":   {},
+			"0.description.down": {},
+		}
+		migrations, err := ReadDir(fileSys, ".")
+		assert.EqualError(t, err, multierror.Append(nil, errWithFileName{inner: ErrDuplicatedIdx, fileName: "0.description.up, 0.description.down, 0.description.sql"}).Error())
+		assert.Equal(t, []Migration(nil), migrations)
+	})
+	t.Run("returns error for directive in up/down file", func(t *testing.T) {
+		fileSys := fstest.MapFS{
+			"0.description.up":   {Data: []byte("-- +dbmigrat Up")},
+			"0.description.down": {},
+		}
+		migrations, err := ReadDir(fileSys, ".")
+		assert.EqualError(t, err, multierror.Append(nil, errWithFileName{inner: ErrSectionInTwoFiles, fileName: "0.description.up"}).Error())
+		assert.Equal(t, []Migration(nil), migrations)
+	})
+	t.Run("reads nested directories in recursive mode", func(t *testing.T) {
+		fileSys := fstest.MapFS{
+			"migrations/2021/0.create_user_table.up.sql":     {Data: []byte("create table users (id serial primary key);")},
+			"migrations/2021/0.create_user_table.down.sql":   {Data: []byte("drop table users;")},
+			"migrations/2022/users/1.add_username_column.up": {Data: []byte("alter table users add column username varchar(32);")},
+			"migrations/2022/1.add_username_column.down":     {Data: []byte("alter table users drop column username;")},
+		}
+		migrations, err := ReadDir(fileSys, "migrations", Recursive())
+		assert.NoError(t, err)
+		assert.Equal(t, expected, migrations)
+
+		migrations, err = ReadDir(fileSys, "migrations")
+		assert.EqualError(t, err, multierror.Append(
+			nil,
+			errWithFileName{inner: ErrContainsDirectory, fileName: "2021"},
+			errWithFileName{inner: ErrContainsDirectory, fileName: "2022"},
+		).Error())
+		assert.Equal(t, []Migration(nil), migrations)
+	})
+	t.Run("returns error with both paths for duplicated index in recursive mode", func(t *testing.T) {
+		fileSys := fstest.MapFS{
+			"2021/0.init.sql": {},
+			"2022/0.init.sql": {},
+		}
+		migrations, err := ReadDir(fileSys, ".", Recursive())
+		assert.EqualError(t, err, multierror.Append(nil, errWithFileName{inner: ErrDuplicatedIdx, fileName: "2021/0.init.sql, 2022/0.init.sql"}).Error())
+		assert.Equal(t, []Migration(nil), migrations)
+	})
+	t.Run("returns error with both paths for duplicated up file in recursive mode", func(t *testing.T) {
+		fileSys := fstest.MapFS{
+			"2021/0.init.up.sql":   {},
+			"2022/0.init.up.sql":   {},
+			"2022/0.init.down.sql": {},
+		}
+		migrations, err := ReadDir(fileSys, ".", Recursive())
+		assert.EqualError(t, err, multierror.Append(nil, errWithFileName{inner: ErrDuplicatedIdx, fileName: "2021/0.init.up.sql, 2022/0.init.up.sql, 2022/0.init.down.sql"}).Error())
+		assert.Equal(t, []Migration(nil), migrations)
+
+		delete(fileSys, "2022/0.init.down.sql")
+		migrations, err = ReadDir(fileSys, ".", Recursive())
+		assert.EqualError(t, err, multierror.Append(nil, errWithFileName{inner: ErrDuplicatedIdx, fileName: "2021/0.init.up.sql, 2022/0.init.up.sql"}).Error())
+		assert.Equal(t, []Migration(nil), migrations)
+	})
+	t.Run("reads versioned migrations", func(t *testing.T) {
+		fileSys := fstest.MapFS{
+			"20211020100000_add_username_column.up.sql":   {Data: []byte("alter table users add column username varchar(32);")},
+			"20211020100000_add_username_column.down.sql": {Data: []byte("alter table users drop column username;")},
+			"20211018143000.create_user_table.sql":        {Data: []byte("-- +dbmigrat Up\ncreate table users (id serial primary key);\n-- +dbmigrat Down\ndrop table users;")},
+		}
+		migrations, err := ReadDir(fileSys, ".", Versioned())
+		assert.NoError(t, err)
+		assert.Equal(t, []Migration{
+			{Description: "create_user_table", Version: "20211018143000", Up: expected[0].Up, Down: expected[0].Down},
+			{Description: "add_username_column", Version: "20211020100000", Up: expected[1].Up, Down: expected[1].Down},
+		}, migrations)
+	})
+	t.Run("returns error for invalid versioned file name", func(t *testing.T) {
+		fileSys := fstest.MapFS{
+			"_add_users.up.sql": {},
+		}
+		migrations, err := ReadDir(fileSys, ".", Versioned())
+		assert.EqualError(t, err, multierror.Append(nil, errWithFileName{inner: ErrFileNameVersion, fileName: "_add_users.up.sql"}).Error())
+		assert.Equal(t, []Migration(nil), migrations)
+	})
+	t.Run("reads irreversible migrations without down file", func(t *testing.T) {
+		fileSys := fstest.MapFS{
+			"0.create_user_table.up.sql":   {Data: []byte("create table users (id serial primary key);")},
+			"0.create_user_table.down.sql": {Data: []byte("drop table users;")},
+			"1.drop_legacy.up.sql":         {Data: []byte("-- +dbmigrat Irreversible\ndrop table legacy;")},
+			"2.drop_legacy_column.sql":     {Data: []byte("-- +dbmigrat Irreversible\n-- +dbmigrat Up\nalter table users drop column legacy;")},
+			"3.description.up":             {},
+		}
+		migrations, err := ReadDir(fileSys, ".")
+		assert.NoError(t, err)
+		assert.Equal(t, []Migration{
+			expected[0],
+			{Description: "drop_legacy", Up: "-- +dbmigrat Irreversible\ndrop table legacy;", Irreversible: true},
+			{Description: "drop_legacy_column", Up: "alter table users drop column legacy;", Irreversible: true},
+		}, migrations)
+	})
+	t.Run("returns all validation errors at once", func(t *testing.T) {
+		fileSys := fstest.MapFS{
+			"dir":                  {Mode: os.ModeDir},
+			"0.description.up":     {},
+			"0.description.down":   {},
+			"1.description.up":     {},
+			"1.other.down":         {},
+			"2.description.foo":    {},
+			"3.description.sql":    {Data: []byte("-- +dbmigrat Foo")},
+			"5.description.up":     {},
+			"5.description.down":   {},
+			"abc.description.down": {},
+		}
+		migrations, err := ReadDir(fileSys, ".")
+		assert.EqualError(t, err, multierror.Append(
+			nil,
+			errWithFileName{inner: ErrContainsDirectory, fileName: "dir"},
+			errWithFileName{inner: ErrFileNameDirection, fileName: "2.description.foo"},
+			errWithFileName{inner: ErrFileNameIdx, fileName: "abc.description.down"},
+			errWithFileName{inner: ErrDescriptionNotEqual, fileName: "1.description.up"},
+			errWithFileName{inner: ErrNotSequential, fileName: "3.description.sql"},
+			errWithFileName{inner: ErrUnknownDirective, fileName: "3.description.sql"},
+			errWithFileName{inner: ErrNotSequential, fileName: "5.description.up"},
+		).Error())
+		assert.Equal(t, []Migration(nil), migrations)
+		assert.True(t, errors.Is(err, ErrNotSequential))
+		assert.True(t, errors.Is(err, ErrDescriptionNotEqual))
+		assert.False(t, errors.Is(err, ErrDuplicatedIdx))
+	})
 	t.Run("returns error for invalid path", func(t *testing.T) {
 		fileSys := fstest.MapFS{}
 		migrations, err := ReadDir(fileSys, "non_existing_dir")
@@ -129,7 +309,7 @@ func TestReadDir(t *testing.T) {
 			"0.description.foo": {},
 		}
 		migrations, err := ReadDir(fileSys, ".")
-		assert.EqualError(t, err, errFileNameDirection.Error())
+		assert.EqualError(t, err, multierror.Append(nil, errWithFileName{inner: ErrFileNameDirection, fileName: "0.description.foo"}).Error())
 		assert.Equal(t, []Migration(nil), migrations)
 	})
 	t.Run("returns error when file open filed", func(t *testing.T) {
@@ -148,6 +328,103 @@ func TestReadDir(t *testing.T) {
 
 }
 
+func TestReadRepos(t *testing.T) {
+	migrationFiles := func(prefix string) fstest.MapFS {
+		return fstest.MapFS{
+			prefix + "/0.init.up.sql":   {Data: []byte("up " + prefix)},
+			prefix + "/0.init.down.sql": {Data: []byte("down " + prefix)},
+		}
+	}
+	merge := func(fileSystems ...fstest.MapFS) fstest.MapFS {
+		result := fstest.MapFS{}
+		for _, fileSys := range fileSystems {
+			for name, file := range fileSys {
+				result[name] = file
+			}
+		}
+		return result
+	}
+
+	t.Run("reads subdirectories of root", func(t *testing.T) {
+		fileSys := merge(migrationFiles("db/auth"), migrationFiles("db/billing"))
+		migrations, repoOrder, err := ReadRepos(fileSys, "db")
+		assert.NoError(t, err)
+		assert.Equal(t, Migrations{
+			"auth":    {{Description: "init", Up: "up db/auth", Down: "down db/auth"}},
+			"billing": {{Description: "init", Up: "up db/billing", Down: "down db/billing"}},
+		}, migrations)
+		assert.Nil(t, repoOrder)
+	})
+	t.Run("reads directories named migrations", func(t *testing.T) {
+		fileSys := merge(
+			migrationFiles("auth/migrations"),
+			migrationFiles("shop/billing/migrations"),
+			fstest.MapFS{"main.go": {}, "auth/auth.go": {}},
+		)
+		migrations, repoOrder, err := ReadRepos(fileSys, ".")
+		assert.NoError(t, err)
+		assert.Equal(t, Migrations{
+			"auth":         {{Description: "init", Up: "up auth/migrations", Down: "down auth/migrations"}},
+			"shop/billing": {{Description: "init", Up: "up shop/billing/migrations", Down: "down shop/billing/migrations"}},
+		}, migrations)
+		assert.Nil(t, repoOrder)
+	})
+	t.Run("reads repo order", func(t *testing.T) {
+		fileSys := merge(
+			migrationFiles("auth/migrations"),
+			migrationFiles("billing/migrations"),
+			fstest.MapFS{"repo_order": {Data: []byte("# auth goes first\nauth\n\nbilling\n")}},
+		)
+		migrations, repoOrder, err := ReadRepos(fileSys, ".")
+		assert.NoError(t, err)
+		assert.Len(t, migrations, 2)
+		assert.Equal(t, RepoOrder{"auth", "billing"}, repoOrder)
+	})
+	t.Run("returns error for invalid repo order", func(t *testing.T) {
+		repos := merge(migrationFiles("auth/migrations"), migrationFiles("billing/migrations"))
+		caseTable := []struct {
+			name        string
+			repoOrder   string
+			errExpected error
+		}{
+			{name: "unknown repo", repoOrder: "auth\nbilling\ndelivery", errExpected: errWithRepo{inner: ErrRepoOrderUnknownRepo, repo: "delivery"}},
+			{name: "duplicated repo", repoOrder: "auth\nbilling\nauth", errExpected: errWithRepo{inner: ErrRepoOrderDuplicatedRepo, repo: "auth"}},
+			{name: "missing repo", repoOrder: "billing", errExpected: errWithRepo{inner: ErrRepoOrderMissingRepo, repo: "auth"}},
+		}
+		for _, testCase := range caseTable {
+			t.Run(testCase.name, func(t *testing.T) {
+				fileSys := merge(repos, fstest.MapFS{"repo_order": {Data: []byte(testCase.repoOrder)}})
+				migrations, repoOrder, err := ReadRepos(fileSys, ".")
+				assert.EqualError(t, err, testCase.errExpected.Error())
+				assert.Nil(t, migrations)
+				assert.Nil(t, repoOrder)
+			})
+		}
+	})
+	t.Run("reads nested directories in recursive mode", func(t *testing.T) {
+		fileSys := merge(migrationFiles("auth/migrations/2021"), migrationFiles("billing/migrations"))
+		migrations, _, err := ReadRepos(fileSys, ".", Recursive())
+		assert.NoError(t, err)
+		assert.Equal(t, Migrations{
+			"auth":    {{Description: "init", Up: "up auth/migrations/2021", Down: "down auth/migrations/2021"}},
+			"billing": {{Description: "init", Up: "up billing/migrations", Down: "down billing/migrations"}},
+		}, migrations)
+	})
+	t.Run("returns error for invalid repo", func(t *testing.T) {
+		fileSys := merge(migrationFiles("auth/migrations"), fstest.MapFS{"billing/migrations/0.init.foo": {}})
+		migrations, repoOrder, err := ReadRepos(fileSys, ".")
+		assert.EqualError(t, err, multierror.Append(nil, errWithFileName{inner: ErrFileNameDirection, fileName: "0.init.foo"}).Error())
+		assert.Nil(t, migrations)
+		assert.Nil(t, repoOrder)
+	})
+	t.Run("returns error for invalid root", func(t *testing.T) {
+		migrations, repoOrder, err := ReadRepos(fstest.MapFS{}, "non_existing_dir")
+		assert.EqualError(t, err, "open non_existing_dir: file does not exist")
+		assert.Nil(t, migrations)
+		assert.Nil(t, repoOrder)
+	})
+}
+
 func TestParseFileNames(t *testing.T) {
 	t.Run("empty", func(t *testing.T) {
 		res, err := parseFileNames([]string{})
@@ -155,8 +432,13 @@ func TestParseFileNames(t *testing.T) {
 		assert.Empty(t, res)
 	})
 	t.Run("invalid file name", func(t *testing.T) {
-		_, err := parseFileNames([]string{"0.description.up", "foo.description.up"})
-		assert.EqualError(t, err, errFileNameIdx.Error())
+		res, err := parseFileNames([]string{"0.description.up", "foo.description.up", "1.description.foo"})
+		assert.EqualError(t, err, multierror.Append(
+			nil,
+			errWithFileName{inner: ErrFileNameIdx, fileName: "foo.description.up"},
+			errWithFileName{inner: ErrFileNameDirection, fileName: "1.description.foo"},
+		).Error())
+		assert.Len(t, res, 1)
 	})
 	t.Run("sorts valid file names", func(t *testing.T) {
 		res, err := parseFileNames([]string{
@@ -173,26 +455,60 @@ func TestParseFileNames(t *testing.T) {
 	})
 }
 
+func TestParseVersionedFileNames(t *testing.T) {
+	res, err := parseVersionedFileNames([]string{
+		"20211020100000_b.down.sql",
+		"20211018143000_a.up.sql",
+		"20211020100000_b.up.sql",
+		"20211018143000_a.down.sql",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, parsedFileNames{
+		{fileName: "20211018143000_a.up.sql", idx: 0, version: "20211018143000", description: "a", direction: up},
+		{fileName: "20211018143000_a.down.sql", idx: 0, version: "20211018143000", description: "a", direction: down},
+		{fileName: "20211020100000_b.up.sql", idx: 1, version: "20211020100000", description: "b", direction: up},
+		{fileName: "20211020100000_b.down.sql", idx: 1, version: "20211020100000", description: "b", direction: down},
+	}, res)
+
+	_, err = parseVersionedFileNames([]string{"20211020100000"})
+	assert.EqualError(t, err, multierror.Append(nil, errWithFileName{inner: ErrFileNameVersion, fileName: "20211020100000"}).Error())
+}
+
 func TestParseFileName(t *testing.T) {
 	t.Run("valid file name", func(t *testing.T) {
 		res, err := parseFileName("0.description.up")
 		assert.NoError(t, err)
 		assert.Equal(t, &parsedFileName{fileName: "0.description.up", idx: 0, description: "description", direction: up}, res)
 	})
+	t.Run("description with dots, hyphens and spaces", func(t *testing.T) {
+		res, err := parseFileName("3.add v2.1-columns.up.sql")
+		assert.NoError(t, err)
+		assert.Equal(t, &parsedFileName{fileName: "3.add v2.1-columns.up.sql", idx: 3, description: "add v2.1-columns", direction: up}, res)
+	})
+	t.Run("description containing direction", func(t *testing.T) {
+		res, err := parseFileName("3.set.up.defaults.down.sql")
+		assert.NoError(t, err)
+		assert.Equal(t, &parsedFileName{fileName: "3.set.up.defaults.down.sql", idx: 3, description: "set.up.defaults", direction: down}, res)
+	})
 	t.Run("less than three parts", func(t *testing.T) {
 		res, err := parseFileName("0.description")
 		assert.Nil(t, res)
-		assert.EqualError(t, err, errFileNameParts.Error())
+		assert.EqualError(t, err, ErrFileNameParts.Error())
 	})
 	t.Run("index not convertable to int", func(t *testing.T) {
 		res, err := parseFileName("a.description.up")
 		assert.Nil(t, res)
-		assert.EqualError(t, err, errFileNameIdx.Error())
+		assert.EqualError(t, err, ErrFileNameIdx.Error())
+	})
+	t.Run("single file", func(t *testing.T) {
+		res, err := parseFileName("4.add_index.sql")
+		assert.NoError(t, err)
+		assert.Equal(t, &parsedFileName{fileName: "4.add_index.sql", idx: 4, description: "add_index", direction: both}, res)
 	})
 	t.Run("invalid direction", func(t *testing.T) {
 		res, err := parseFileName("0.description.UP")
 		assert.Nil(t, res)
-		assert.EqualError(t, err, errFileNameDirection.Error())
+		assert.EqualError(t, err, ErrFileNameDirection.Error())
 	})
 }
 
@@ -0,0 +1,130 @@
+package dbmigrat
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-multierror"
+)
+
+// Repair updates checksums and descriptions saved in migrations log,
+// so they match provided migrations. It is meant for fixing mismatches reported by
+// CheckLogTableIntegrity (IntegrityCheckResult.InvalidChecksums) which are result of intentional edits.
+//
+// toRepair parameter is a map where keys are repositories names, values are indexes
+// of migrations which should be repaired. Migrations not listed in toRepair are never touched.
+// Every listed migration must exist in both migrations log and migrations passed as argument.
+//
+// repairedBy is saved in dbmigrat_repair_log table along with previous and new checksum and description.
+// On database tagged with protected Environment Repair requires WithConfirmation option.
+func Repair(s store, migrations Migrations, toRepair RepoIndexes, repairedBy string, opts ...Option) (int, error) {
+	err := s.begin()
+	if err != nil {
+		return 0, err
+	}
+	err = checkProtection(s, newOptions(opts))
+	if err != nil {
+		return 0, multierror.Append(err, s.rollback())
+	}
+	repairedCount, err := repair(s, migrations, toRepair, repairedBy)
+	if err != nil {
+		return 0, multierror.Append(err, s.rollback())
+	}
+	return repairedCount, s.commit()
+}
+
+func repair(s store, migrations Migrations, toRepair RepoIndexes, repairedBy string) (int, error) {
+	migrationLogs, err := s.fetchAllMigrationLogs()
+	if err != nil {
+		return 0, err
+	}
+	storedLogs := map[Repo]map[int]MigrationLog{}
+	for _, log := range migrationLogs {
+		if storedLogs[log.Repo] == nil {
+			storedLogs[log.Repo] = map[int]MigrationLog{}
+		}
+		storedLogs[log.Repo][log.Idx] = log
+	}
+
+	var logsToUpdate []MigrationLog
+	var repairLogs []repairLog
+	for _, repo := range toRepair.sortedRepos() {
+		for _, idx := range toRepair[repo] {
+			if idx < 0 || idx >= len(migrations[repo]) {
+				return 0, errWithRepoIdx{inner: errRepairMigrationNotFound, repo: repo, idx: idx}
+			}
+			storedLog, ok := storedLogs[repo][idx]
+			if !ok {
+				return 0, errWithRepoIdx{inner: errRepairLogNotFound, repo: repo, idx: idx}
+			}
+			migration := migrations[repo][idx]
+			checksum := sha1Checksum(migration.Up)
+			if storedLog.Checksum == checksum && storedLog.Description == migration.Description {
+				continue
+			}
+			logsToUpdate = append(logsToUpdate, MigrationLog{
+				Idx:         idx,
+				Repo:        repo,
+				Checksum:    checksum,
+				Description: migration.Description,
+			})
+			repairLogs = append(repairLogs, repairLog{
+				Idx:                 idx,
+				Repo:                repo,
+				PreviousChecksum:    storedLog.Checksum,
+				Checksum:            checksum,
+				PreviousDescription: storedLog.Description,
+				Description:         migration.Description,
+				RepairedBy:          repairedBy,
+			})
+		}
+	}
+	if len(logsToUpdate) == 0 {
+		return 0, nil
+	}
+
+	err = s.updateLogs(logsToUpdate)
+	if err != nil {
+		return 0, err
+	}
+	err = s.insertRepairLogs(repairLogs)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(logsToUpdate), nil
+}
+
+// RepoIndexes points to migrations by repositories names and indexes of migrations within repository.
+type RepoIndexes map[Repo][]int
+
+func (ri RepoIndexes) sortedRepos() []Repo {
+	repos := make([]Repo, 0, len(ri))
+	for repo := range ri {
+		repos = append(repos, repo)
+	}
+	sortRepos(repos)
+	return repos
+}
+
+var (
+	errRepairMigrationNotFound = errors.New("migration selected for repair does not exist in passed migrations")
+	errRepairLogNotFound       = errors.New("migration selected for repair does not exist in migrations log")
+)
+
+func (e errWithRepoIdx) Error() string {
+	if e.description != "" {
+		return fmt.Sprintf("%s (%s:%d %s)", e.inner.Error(), e.repo, e.idx, e.description)
+	}
+	return fmt.Sprintf("%s (%s:%d)", e.inner.Error(), e.repo, e.idx)
+}
+
+func (e errWithRepoIdx) Unwrap() error {
+	return e.inner
+}
+
+type errWithRepoIdx struct {
+	inner       error
+	repo        Repo
+	idx         int
+	description string
+}
@@ -0,0 +1,118 @@
+package dbmigrat
+
+import (
+	"github.com/hashicorp/go-multierror"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRepair(t *testing.T) {
+	editedMigrations := Migrations{
+		"auth": {
+			th.migrations1["auth"][0],
+			{Up: `alter table users add column username varchar(64)`, Down: `alter table users drop column username`, Description: "add longer username column"},
+		},
+		"billing": {
+			{Up: `create table orders (id serial primary key, user_id integer references users (id))`, Down: `drop table orders`, Description: `create orders table`},
+		},
+	}
+	before := func(t *testing.T) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+		_, err := Migrate(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"})
+		assert.NoError(t, err)
+	}
+
+	t.Run("repairs only selected migrations", func(t *testing.T) {
+		before(t)
+
+		repairedCount, err := Repair(th.pgStore, editedMigrations, RepoIndexes{"auth": {0, 1}}, "john")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, repairedCount)
+
+		checkRes, err := CheckLogTableIntegrity(th.pgStore, editedMigrations)
+		assert.NoError(t, err)
+		assert.True(t, checkRes.IsCorrupted)
+		assert.Empty(t, checkRes.InvalidChecksums["auth"])
+		assert.Len(t, checkRes.InvalidChecksums["billing"], 1)
+
+		var repairLogs []repairLog
+		assert.NoError(t, th.db.Select(&repairLogs, `select * from dbmigrat_repair_log`))
+		assert.Len(t, repairLogs, 1)
+		assert.Equal(t, Repo("auth"), repairLogs[0].Repo)
+		assert.Equal(t, 1, repairLogs[0].Idx)
+		assert.Equal(t, sha1Checksum(th.migrations1["auth"][1].Up), repairLogs[0].PreviousChecksum)
+		assert.Equal(t, sha1Checksum(editedMigrations["auth"][1].Up), repairLogs[0].Checksum)
+		assert.Equal(t, "add username column", repairLogs[0].PreviousDescription)
+		assert.Equal(t, "add longer username column", repairLogs[0].Description)
+		assert.Equal(t, "john", repairLogs[0].RepairedBy)
+
+		// # Check if repeated repair does nothing
+		repairedCount, err = Repair(th.pgStore, editedMigrations, RepoIndexes{"auth": {0, 1}}, "john")
+		assert.NoError(t, err)
+		assert.Equal(t, 0, repairedCount)
+	})
+
+	t.Run("repairs checksum of migration converted to single file", func(t *testing.T) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+		twoFiles, err := ReadDir(fstest.MapFS{
+			"0.create_user_table.up.sql":   {Data: []byte("create table users (id serial primary key);\n")},
+			"0.create_user_table.down.sql": {Data: []byte("drop table users;\n")},
+		}, ".")
+		assert.NoError(t, err)
+		_, err = Migrate(th.pgStore, Migrations{"auth": twoFiles}, RepoOrder{"auth"})
+		assert.NoError(t, err)
+
+		singleFile, err := ReadDir(fstest.MapFS{
+			"0.create_user_table.sql": {Data: []byte("-- +dbmigrat Up\ncreate table users (id serial primary key);\n-- +dbmigrat Down\ndrop table users;\n")},
+		}, ".")
+		assert.NoError(t, err)
+		checkRes, err := CheckLogTableIntegrity(th.pgStore, Migrations{"auth": singleFile})
+		assert.NoError(t, err)
+		assert.Len(t, checkRes.InvalidChecksums["auth"], 1)
+
+		repairedCount, err := Repair(th.pgStore, Migrations{"auth": singleFile}, RepoIndexes{"auth": {0}}, "john")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, repairedCount)
+		checkRes, err = CheckLogTableIntegrity(th.pgStore, Migrations{"auth": singleFile})
+		assert.NoError(t, err)
+		assert.False(t, checkRes.IsCorrupted)
+	})
+
+	t.Run("refuses to repair migration missing in log or in migrations", func(t *testing.T) {
+		before(t)
+
+		repairedCount, err := Repair(th.pgStore, editedMigrations, RepoIndexes{"billing": {0}, "auth": {2}}, "john")
+		assert.EqualError(t, err, multierror.Append(errWithRepoIdx{inner: errRepairMigrationNotFound, repo: "auth", idx: 2}).Error())
+		assert.Equal(t, 0, repairedCount)
+
+		repairedCount, err = Repair(th.pgStore, th.migrations2, RepoIndexes{"billing": {1}}, "john")
+		assert.EqualError(t, err, multierror.Append(errWithRepoIdx{inner: errRepairLogNotFound, repo: "billing", idx: 1}).Error())
+		assert.Equal(t, 0, repairedCount)
+
+		checkRes, err := CheckLogTableIntegrity(th.pgStore, editedMigrations)
+		assert.NoError(t, err)
+		assert.Len(t, checkRes.InvalidChecksums["billing"], 1)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		before(t)
+
+		caseTable := caseTable{
+			{name: "tx begin fail", storeMock: errorStoreMock{wrapped: th.pgStore, errBegin: true}, errExpected: exampleErr},
+			{name: "fetchAllMigrationLogs fail", storeMock: errorStoreMock{wrapped: th.pgStore, errFetchAllMigrationLogs: true}, errExpected: exampleMultiErr},
+			{name: "updateLogs fail", storeMock: errorStoreMock{wrapped: th.pgStore, errUpdateLogs: true}, errExpected: exampleMultiErr},
+			{name: "insertRepairLogs fail", storeMock: errorStoreMock{wrapped: th.pgStore, errInsertRepairLogs: true}, errExpected: exampleMultiErr},
+		}
+
+		for _, testCase := range caseTable {
+			t.Run(testCase.name, func(t *testing.T) {
+				repairedCount, err := Repair(testCase.storeMock, editedMigrations, RepoIndexes{"billing": {0}}, "john")
+				assert.EqualError(t, err, testCase.errExpected.Error())
+				assert.Equal(t, 0, repairedCount)
+			})
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package dbmigrat
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// NewMigration creates files of new migration in directory under dirPath (it is created when missing)
+// and returns paths of created files. Index of migration is the next one after the highest index found in directory.
+// Description is sanitized - it is lowercased and every sequence of characters other than letters and digits
+// is replaced with "_" (eg. "Add username column" becomes "add_username_column").
+//
+// By default pair of empty up and down files is created. SingleFile option makes it create
+// single file with "-- +dbmigrat Up" and "-- +dbmigrat Down" sections,
+// VersionedAt option prefixes files names with version instead of index (see Versioned option of ReadDir),
+// RecursiveIdx option makes the highest index be searched in subdirectories too.
+// Existing files are never overwritten.
+func NewMigration(dirPath, description string, opts ...NewOption) ([]string, error) {
+	options := newScaffoldOptions(opts)
+	description = sanitizeDescription(description)
+	if description == "" {
+		return nil, ErrEmptyDescription
+	}
+
+	err := os.MkdirAll(dirPath, 0755)
+	if err != nil {
+		return nil, err
+	}
+	prefix := options.version
+	if prefix == "" {
+		idx, err := nextMigrationIdx(dirPath, options.recursive)
+		if err != nil {
+			return nil, err
+		}
+		prefix = fmt.Sprint(idx)
+	}
+
+	files := []struct{ name, content string }{
+		{name: fmt.Sprintf("%s.%s.%s.%s", prefix, description, up, singleFileExt)},
+		{name: fmt.Sprintf("%s.%s.%s.%s", prefix, description, down, singleFileExt)},
+	}
+	if options.singleFile {
+		files = files[:1]
+		files[0].name = fmt.Sprintf("%s.%s.%s", prefix, description, singleFileExt)
+		files[0].content = directivePrefix + directiveUp + "\n\n" + directivePrefix + directiveDown + "\n"
+	}
+
+	var created []string
+	for _, file := range files {
+		filePath := filepath.Join(dirPath, file.name)
+		err = writeNewFile(filePath, file.content)
+		if err != nil {
+			return created, err
+		}
+		created = append(created, filePath)
+	}
+	return created, nil
+}
+
+// nextMigrationIdx returns index following the highest index of migration files in directory
+// (and its subdirectories when recursive is true). Files with invalid names are ignored.
+func nextMigrationIdx(dirPath string, recursive bool) (int, error) {
+	nextIdx := 0
+	err := filepath.WalkDir(dirPath, func(filePath string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if dirEntry.IsDir() {
+			if filePath != dirPath && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		parsed, err := parseFileName(dirEntry.Name())
+		if err == nil && parsed.idx >= nextIdx {
+			nextIdx = parsed.idx + 1
+		}
+		return nil
+	})
+	return nextIdx, err
+}
+
+func writeNewFile(filePath, content string) error {
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(content)
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func sanitizeDescription(description string) string {
+	sanitized := nonAlphanumeric.ReplaceAllString(strings.ToLower(description), "_")
+	return strings.Trim(sanitized, "_")
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^a-z0-9]+`)
+
+// ErrEmptyDescription is returned by NewMigration func when description contains no letters or digits.
+var ErrEmptyDescription = errors.New("migration's description must contain letters or digits")
@@ -0,0 +1,90 @@
+package dbmigrat
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewMigration(t *testing.T) {
+	t.Run("creates pair of files with next index", func(t *testing.T) {
+		dir := t.TempDir()
+		for name, content := range map[string]string{
+			"0.create_users.up.sql":   "create table users (id serial primary key);",
+			"0.create_users.down.sql": "drop table users;",
+			"1.add_email.sql":         "-- +dbmigrat Up\n-- +dbmigrat Down\n",
+			"README.md":               "",
+		} {
+			assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		}
+
+		created, err := NewMigration(dir, "  Add username column!")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{
+			filepath.Join(dir, "2.add_username_column.up.sql"),
+			filepath.Join(dir, "2.add_username_column.down.sql"),
+		}, created)
+
+		assert.NoError(t, os.Remove(filepath.Join(dir, "README.md")))
+		migrations, err := ReadDir(os.DirFS(dir), ".")
+		assert.NoError(t, err)
+		assert.Len(t, migrations, 3)
+		assert.Equal(t, "add_username_column", migrations[2].Description)
+	})
+	t.Run("looks for the highest index in subdirectories of recursive repo", func(t *testing.T) {
+		dir := t.TempDir()
+		assert.NoError(t, os.Mkdir(filepath.Join(dir, "users"), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "0.create_users.sql"), []byte("-- +dbmigrat Up\n-- +dbmigrat Down\n"), 0644))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "users", "1.add_email.sql"), []byte("-- +dbmigrat Up\n-- +dbmigrat Down\n"), 0644))
+
+		// # Subdirectories are skipped by default
+		nextIdx, err := nextMigrationIdx(dir, false)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, nextIdx)
+
+		created, err := NewMigration(dir, "add username", SingleFile(), RecursiveIdx())
+		assert.NoError(t, err)
+		assert.Equal(t, []string{filepath.Join(dir, "2.add_username.sql")}, created)
+
+		migrations, err := ReadDir(os.DirFS(dir), ".", Recursive())
+		assert.NoError(t, err)
+		assert.Len(t, migrations, 3)
+	})
+	t.Run("creates single file in missing directory", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "auth", "migrations")
+
+		created, err := NewMigration(dir, "create users", SingleFile())
+		assert.NoError(t, err)
+		assert.Equal(t, []string{filepath.Join(dir, "0.create_users.sql")}, created)
+
+		migrations, err := ReadDir(os.DirFS(dir), ".")
+		assert.NoError(t, err)
+		assert.Equal(t, []Migration{{Description: "create_users"}}, migrations)
+	})
+	t.Run("creates versioned files", func(t *testing.T) {
+		dir := t.TempDir()
+
+		created, err := NewMigration(dir, "create users", VersionedAt(time.Date(2021, 10, 18, 14, 30, 0, 0, time.UTC)))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{
+			filepath.Join(dir, "20211018143000.create_users.up.sql"),
+			filepath.Join(dir, "20211018143000.create_users.down.sql"),
+		}, created)
+
+		migrations, err := ReadDir(os.DirFS(dir), ".", Versioned())
+		assert.NoError(t, err)
+		assert.Equal(t, []Migration{{Description: "create_users", Version: "20211018143000"}}, migrations)
+
+		// # Existing files are not overwritten
+		created, err = NewMigration(dir, "create users", VersionedAt(time.Date(2021, 10, 18, 14, 30, 0, 0, time.UTC)))
+		assert.True(t, os.IsExist(err))
+		assert.Nil(t, created)
+	})
+	t.Run("returns error for empty description", func(t *testing.T) {
+		created, err := NewMigration(t.TempDir(), " -- ")
+		assert.EqualError(t, err, ErrEmptyDescription.Error())
+		assert.Nil(t, created)
+	})
+}
@@ -0,0 +1,98 @@
+package dbmigrat
+
+// Status reports which migrations of repos listed in repoOrder are applied and which are pending.
+// It does not modify the store.
+func Status(s store, migrations Migrations, repoOrder RepoOrder) (*StatusResult, error) {
+	lastMigrationSerial, err := s.fetchLastMigrationSerial()
+	if err != nil {
+		return nil, err
+	}
+	migrationLogs, err := s.fetchAllMigrationLogs()
+	if err != nil {
+		return nil, err
+	}
+	logsByRepo := map[Repo][]MigrationLog{}
+	for _, log := range migrationLogs {
+		logsByRepo[log.Repo] = append(logsByRepo[log.Repo], log)
+	}
+
+	result := &StatusResult{LastMigrationSerial: lastMigrationSerial, Repos: []RepoStatus{}}
+	for _, orderedRepo := range repoOrder {
+		repoStatus := RepoStatus{Repo: orderedRepo, LastAppliedIdx: -1, Pending: []int{}}
+		applied := map[int]bool{}
+		for _, log := range logsByRepo[orderedRepo] {
+			applied[log.Idx] = true
+			repoStatus.Applied++
+			if log.Idx > repoStatus.LastAppliedIdx {
+				repoStatus.LastAppliedIdx = log.Idx
+			}
+		}
+		for idx := range migrations[orderedRepo] {
+			if !applied[idx] {
+				repoStatus.Pending = append(repoStatus.Pending, idx)
+			}
+		}
+		result.Repos = append(result.Repos, repoStatus)
+	}
+
+	return result, nil
+}
+
+// StatusResult contains status of every repo passed to Status func.
+// LastMigrationSerial is -1 when no migration has been applied yet.
+// Result is encoded to JSON with camelCase keys, empty lists are encoded as [].
+type StatusResult struct {
+	LastMigrationSerial int          `json:"lastMigrationSerial"`
+	Repos               []RepoStatus `json:"repos"`
+}
+
+// RepoStatus contains number of applied migrations of Repo, index of the last applied one
+// (-1 when none) and indexes of passed migrations which are not applied.
+type RepoStatus struct {
+	Repo           Repo  `json:"repo"`
+	Applied        int   `json:"applied"`
+	LastAppliedIdx int   `json:"lastAppliedIdx"`
+	Pending        []int `json:"pending"`
+}
+
+// Plan returns migrations which would be applied by Migrate func called with the same arguments,
+// in order in which they would be applied. It does not modify the store.
+func Plan(s store, migrations Migrations, repoOrder RepoOrder, opts ...Option) (*PlanResult, error) {
+	state, err := fetchMigrationState(s, migrations, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PlanResult{MigrationSerial: state.lastMigrationSerial + 1, Migrations: []PlannedMigration{}}
+	for _, orderedRepo := range repoOrder {
+		repoMigrations := migrations[orderedRepo]
+		for _, idx := range state.indexesToRun(orderedRepo, repoMigrations) {
+			result.Migrations = append(result.Migrations, PlannedMigration{
+				Repo:          orderedRepo,
+				Idx:           idx,
+				Description:   repoMigrations[idx].Description,
+				Version:       repoMigrations[idx].Version,
+				NoTransaction: repoMigrations[idx].NoTransaction,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// PlanResult contains migrations planned by Plan func. MigrationSerial is serial
+// which would be saved in migrations log for them.
+// Result is encoded to JSON with camelCase keys, empty lists are encoded as [].
+type PlanResult struct {
+	MigrationSerial int                `json:"migrationSerial"`
+	Migrations      []PlannedMigration `json:"migrations"`
+}
+
+// PlannedMigration identifies migration planned to be applied.
+type PlannedMigration struct {
+	Repo          Repo   `json:"repo"`
+	Idx           int    `json:"idx"`
+	Description   string `json:"description"`
+	Version       string `json:"version"`
+	NoTransaction bool   `json:"noTransaction"`
+}
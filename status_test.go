@@ -0,0 +1,106 @@
+package dbmigrat
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestStatus(t *testing.T) {
+	assert.NoError(t, th.resetDB())
+	assert.NoError(t, th.pgStore.CreateLogTable())
+
+	res, err := Status(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"})
+	assert.NoError(t, err)
+	assert.Equal(t, &StatusResult{
+		LastMigrationSerial: -1,
+		Repos: []RepoStatus{
+			{Repo: "auth", Applied: 0, LastAppliedIdx: -1, Pending: []int{0, 1}},
+			{Repo: "billing", Applied: 0, LastAppliedIdx: -1, Pending: []int{0}},
+		},
+	}, res)
+
+	_, err = Migrate(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"})
+	assert.NoError(t, err)
+	res, err = Status(th.pgStore, th.migrations2, RepoOrder{"auth", "billing", "delivery"})
+	assert.NoError(t, err)
+	assert.Equal(t, &StatusResult{
+		LastMigrationSerial: 0,
+		Repos: []RepoStatus{
+			{Repo: "auth", Applied: 2, LastAppliedIdx: 1, Pending: []int{}},
+			{Repo: "billing", Applied: 1, LastAppliedIdx: 0, Pending: []int{1}},
+			{Repo: "delivery", Applied: 0, LastAppliedIdx: -1, Pending: []int{0}},
+		},
+	}, res)
+
+	res, err = Status(errorStoreMock{wrapped: th.pgStore, errFetchAllMigrationLogs: true}, th.migrations2, RepoOrder{"auth"})
+	assert.EqualError(t, err, exampleErr.Error())
+	assert.Nil(t, res)
+}
+
+func TestPlan(t *testing.T) {
+	assert.NoError(t, th.resetDB())
+	assert.NoError(t, th.pgStore.CreateLogTable())
+	_, err := Migrate(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"})
+	assert.NoError(t, err)
+
+	res, err := Plan(th.pgStore, th.migrations2, RepoOrder{"auth", "billing", "delivery"})
+	assert.NoError(t, err)
+	assert.Equal(t, &PlanResult{
+		MigrationSerial: 1,
+		Migrations: []PlannedMigration{
+			{Repo: "billing", Idx: 1, Description: th.migrations2["billing"][1].Description},
+			{Repo: "delivery", Idx: 0, Description: th.migrations2["delivery"][0].Description},
+		},
+	}, res)
+
+	// # Plan does not apply migrations
+	logCount, err := Migrate(th.pgStore, th.migrations2, RepoOrder{"auth", "billing", "delivery"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, logCount)
+
+	res, err = Plan(errorStoreMock{wrapped: th.pgStore, errFetchLastMigrationIndexes: true}, th.migrations2, RepoOrder{"auth"})
+	assert.EqualError(t, err, exampleErr.Error())
+	assert.Nil(t, res)
+}
+
+func TestResultsJSON(t *testing.T) {
+	appliedAt := time.Date(2021, 10, 18, 14, 30, 0, 0, time.UTC)
+	caseTable := []struct {
+		name     string
+		result   interface{}
+		expected string
+	}{
+		{
+			name:     "status",
+			result:   &StatusResult{LastMigrationSerial: 0, Repos: []RepoStatus{{Repo: "auth", Applied: 1, LastAppliedIdx: 0, Pending: []int{}}}},
+			expected: `{"lastMigrationSerial":0,"repos":[{"repo":"auth","applied":1,"lastAppliedIdx":0,"pending":[]}]}`,
+		},
+		{
+			name:     "plan",
+			result:   &PlanResult{MigrationSerial: 1, Migrations: []PlannedMigration{{Repo: "auth", Idx: 1, Description: "add username column"}}},
+			expected: `{"migrationSerial":1,"migrations":[{"repo":"auth","idx":1,"description":"add username column","version":"","noTransaction":false}]}`,
+		},
+		{
+			name: "integrity check",
+			result: func() *IntegrityCheckResult {
+				result := newIntegrityCheckResult()
+				result.IsCorrupted = true
+				result.InvalidChecksums["auth"] = []MigrationLog{{Idx: 0, Repo: "auth", Checksum: "abc", AppliedAt: appliedAt, Description: "create user table"}}
+				return result
+			}(),
+			expected: `{"isCorrupted":true,"redundantRepos":{},"redundantMigrations":{},` +
+				`"invalidChecksums":{"auth":[{"idx":0,"repo":"auth","migrationSerial":0,"checksum":"abc","appliedAt":"2021-10-18T14:30:00Z","description":"create user table","version":"","gapFilled":false}]},` +
+				`"missingMigrations":{},"outOfOrderMigrations":{},"reorderedMigrations":{},"missingSerials":[]}`,
+		},
+	}
+
+	for _, testCase := range caseTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			encoded, err := json.Marshal(testCase.result)
+			assert.NoError(t, err)
+			assert.JSONEq(t, testCase.expected, string(encoded))
+		})
+	}
+}
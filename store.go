@@ -1,8 +1,12 @@
 package dbmigrat
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"github.com/hashicorp/go-multierror"
 	"github.com/jmoiron/sqlx"
+	"strconv"
 	"time"
 )
 
@@ -19,14 +23,45 @@ func (s PostgresStore) CreateLogTable() error {
 		    applied_at       timestamp    not null default current_timestamp,
 		    description      text         not null,
 		    primary key (idx, repo)
-		)
-	`)
+		);
+		alter table dbmigrat_log add column if not exists version varchar(255) not null default '';
+		alter table dbmigrat_log add column if not exists gap_filled boolean not null default false;
+		create table if not exists dbmigrat_repair_log
+		(
+		    idx                  integer      not null,
+		    repo                 varchar(255) not null,
+		    previous_checksum    bytea        not null,
+		    checksum             bytea        not null,
+		    previous_description text         not null,
+		    description          text         not null,
+		    repaired_by          text         not null,
+		    repaired_at          timestamp    not null default current_timestamp
+		);
+		create table if not exists dbmigrat_schema_snapshot
+		(
+		    migration_serial integer   not null primary key,
+		    snapshot         jsonb     not null,
+		    captured_at      timestamp not null default current_timestamp
+		);
+	` + createEnvironmentTable)
 
 	return err
 }
 
-func (s PostgresStore) fetchAllMigrationLogs() ([]migrationLog, error) {
-	var migrationLogs []migrationLog
+// createEnvironmentTable is part of CreateLogTable. It is also run by SetEnvironment,
+// so databases which log table has been created by older version might be tagged without migrating it.
+const createEnvironmentTable = `
+		create table if not exists dbmigrat_environment
+		(
+		    id         integer      not null primary key default 1 check (id = 1),
+		    name       varchar(255) not null,
+		    protected  boolean      not null,
+		    updated_at timestamp    not null default current_timestamp
+		)
+	`
+
+func (s PostgresStore) fetchAllMigrationLogs() ([]MigrationLog, error) {
+	var migrationLogs []MigrationLog
 	err := s.getDbAccessor().Select(&migrationLogs, `select * from dbmigrat_log`)
 	return migrationLogs, err
 }
@@ -43,10 +78,10 @@ func (s PostgresStore) fetchLastMigrationSerial() (int, error) {
 	return int(result.Int32), nil
 }
 
-func (s PostgresStore) insertLogs(logs []migrationLog) error {
+func (s PostgresStore) insertLogs(logs []MigrationLog) error {
 	_, err := s.getDbAccessor().NamedExec(`
-			insert into dbmigrat_log (idx, repo, migration_serial, checksum, applied_at, description)
-			values (:idx, :repo, :migration_serial, :checksum, default, :description)
+			insert into dbmigrat_log (idx, repo, migration_serial, checksum, applied_at, description, version, gap_filled)
+			values (:idx, :repo, :migration_serial, :checksum, default, :description, :version, :gap_filled)
 			`,
 		logs,
 	)
@@ -72,6 +107,30 @@ func (s PostgresStore) fetchLastMigrationIndexes() (map[Repo]int, error) {
 	return repoToMaxIdx, nil
 }
 
+func (s PostgresStore) fetchMissingMigrationIndexes() (map[Repo][]int, error) {
+	var dest []struct {
+		Idx  int
+		Repo Repo
+	}
+	err := s.getDbAccessor().Select(&dest, `
+		select s.idx, l.repo
+		from (select repo, max(idx) as max_idx from dbmigrat_log group by repo) l
+		cross join lateral generate_series(0, l.max_idx) as s(idx)
+		where not exists (select 1 from dbmigrat_log d where d.repo = l.repo and d.idx = s.idx)
+		order by l.repo, s.idx
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	repoToMissingIndexes := map[Repo][]int{}
+	for _, res := range dest {
+		repoToMissingIndexes[res.Repo] = append(repoToMissingIndexes[res.Repo], res.Idx)
+	}
+
+	return repoToMissingIndexes, nil
+}
+
 func (s PostgresStore) fetchReverseMigrationIndexesAfterSerial(serial int) (map[Repo][]int, error) {
 	var dest []struct {
 		Idx  int
@@ -90,7 +149,31 @@ func (s PostgresStore) fetchReverseMigrationIndexesAfterSerial(serial int) (map[
 	return repoToReverseMigrationIndexes, nil
 }
 
-func (s PostgresStore) deleteLogs(logs []migrationLog) error {
+func (s PostgresStore) updateLogs(logs []MigrationLog) error {
+	for _, log := range logs {
+		_, err := s.getDbAccessor().Exec(
+			`update dbmigrat_log set checksum = $1, description = $2 where idx = $3 and repo = $4`,
+			log.Checksum, log.Description, log.Idx, log.Repo,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s PostgresStore) insertRepairLogs(logs []repairLog) error {
+	_, err := s.getDbAccessor().NamedExec(`
+			insert into dbmigrat_repair_log (idx, repo, previous_checksum, checksum, previous_description, description, repaired_by, repaired_at)
+			values (:idx, :repo, :previous_checksum, :checksum, :previous_description, :description, :repaired_by, default)
+			`,
+		logs,
+	)
+
+	return err
+}
+
+func (s PostgresStore) deleteLogs(logs []MigrationLog) error {
 	for _, log := range logs {
 		_, err := s.getDbAccessor().Exec(`delete from dbmigrat_log where idx = $1 and repo = $2`, log.Idx, log.Repo)
 		if err != nil {
@@ -100,6 +183,113 @@ func (s PostgresStore) deleteLogs(logs []migrationLog) error {
 	return nil
 }
 
+func (s PostgresStore) fetchSchemaSnapshot() (SchemaSnapshot, error) {
+	var snapshot SchemaSnapshot
+	err := s.getDbAccessor().Select(&snapshot, `
+		select entry from (
+			select 'table ' || table_schema || '.' || table_name || ' ' || lower(table_type) as entry
+			from information_schema.tables
+			where table_schema not like 'pg\_%' and table_schema <> 'information_schema'
+			  and table_name not like 'dbmigrat\_%'
+			union all
+			select 'column ' || table_schema || '.' || table_name || '.' || column_name || ' ' || data_type
+			           || coalesce('(' || character_maximum_length || ')', '')
+			           || case when is_nullable = 'NO' then ' not null' else '' end
+			           || coalesce(' default ' || column_default, '')
+			from information_schema.columns
+			where table_schema not like 'pg\_%' and table_schema <> 'information_schema'
+			  and table_name not like 'dbmigrat\_%'
+			union all
+			select 'constraint ' || n.nspname || '.' || c.relname || '.' || con.conname || ' ' || pg_get_constraintdef(con.oid)
+			from pg_constraint con
+			join pg_class c on c.oid = con.conrelid
+			join pg_namespace n on n.oid = c.relnamespace
+			where n.nspname not like 'pg\_%' and n.nspname <> 'information_schema'
+			  and c.relname not like 'dbmigrat\_%'
+			union all
+			select 'index ' || schemaname || '.' || indexname || ' ' || indexdef
+			from pg_indexes
+			where schemaname not like 'pg\_%' and schemaname <> 'information_schema'
+			  and tablename not like 'dbmigrat\_%'
+		) entries
+		order by entry
+	`)
+	return snapshot, err
+}
+
+func (s PostgresStore) insertSchemaSnapshot(serial int, snapshot SchemaSnapshot) error {
+	encoded, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	_, err = s.getDbAccessor().Exec(`
+		insert into dbmigrat_schema_snapshot (migration_serial, snapshot, captured_at)
+		values ($1, $2, default)
+		on conflict (migration_serial) do update set snapshot = excluded.snapshot, captured_at = excluded.captured_at
+	`, serial, string(encoded))
+	return err
+}
+
+func (s PostgresStore) fetchLastSchemaSnapshot() (int, SchemaSnapshot, error) {
+	var dest struct {
+		MigrationSerial int `db:"migration_serial"`
+		Snapshot        string
+	}
+	err := s.getDbAccessor().Get(&dest, `select migration_serial, snapshot from dbmigrat_schema_snapshot order by migration_serial desc limit 1`)
+	if err == sql.ErrNoRows {
+		return -1, nil, nil
+	}
+	if err != nil {
+		return -1, nil, err
+	}
+	var snapshot SchemaSnapshot
+	err = json.Unmarshal([]byte(dest.Snapshot), &snapshot)
+	if err != nil {
+		return -1, nil, err
+	}
+	return dest.MigrationSerial, snapshot, nil
+}
+
+func (s PostgresStore) deleteSchemaSnapshotsAfterSerial(serial int) error {
+	_, err := s.getDbAccessor().Exec(`delete from dbmigrat_schema_snapshot where migration_serial > $1`, serial)
+	return err
+}
+
+// fetchEnvironment returns nil when environment table does not exist (it has been created by older version
+// of CreateLogTable). Its existence is checked upfront, as failed query would abort current transaction.
+func (s PostgresStore) fetchEnvironment() (*Environment, error) {
+	var tableExists bool
+	err := s.getDbAccessor().Get(&tableExists, `select to_regclass('dbmigrat_environment') is not null`)
+	if err != nil {
+		return nil, err
+	}
+	if !tableExists {
+		return nil, nil
+	}
+	var environment Environment
+	err = s.getDbAccessor().Get(&environment, `select name, protected from dbmigrat_environment`)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &environment, nil
+}
+
+func (s PostgresStore) saveEnvironment(environment Environment) error {
+	_, err := s.getDbAccessor().Exec(createEnvironmentTable)
+	if err != nil {
+		return err
+	}
+	_, err = s.getDbAccessor().NamedExec(`
+		insert into dbmigrat_environment (name, protected)
+		values (:name, :protected)
+		on conflict (id) do update set name = excluded.name, protected = excluded.protected, updated_at = default
+	`, environment)
+	return err
+}
+
 func (s *PostgresStore) begin() error {
 	tx, err := s.DB.Beginx()
 	s.tx = tx
@@ -107,6 +297,9 @@ func (s *PostgresStore) begin() error {
 }
 
 func (s *PostgresStore) rollback() error {
+	if s.tx == nil {
+		return nil
+	}
 	err := s.tx.Rollback()
 	s.tx = nil
 	return err
@@ -118,11 +311,69 @@ func (s *PostgresStore) commit() error {
 	return err
 }
 
-func (s PostgresStore) exec(query string) error {
-	_, err := s.getDbAccessor().Exec(query)
+// exec runs query with non-zero limits applied to statement_timeout and lock_timeout settings.
+// Settings are applied locally to transaction or, outside of transaction, to session of dedicated
+// connection. Their previous values are restored afterwards.
+func (s PostgresStore) exec(query string, limits timeouts) error {
+	settings := limits.settings()
+	if len(settings) == 0 {
+		_, err := s.getDbAccessor().Exec(query)
+		return err
+	}
+	ctx := context.Background()
+	if s.tx != nil {
+		return execWithSettings(ctx, s.tx, query, settings, true)
+	}
+	conn, err := s.DB.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return execWithSettings(ctx, conn, query, settings, false)
+}
+
+func execWithSettings(ctx context.Context, accessor contextAccessor, query string, settings []setting, local bool) error {
+	previous := make([]string, len(settings))
+	for i, st := range settings {
+		err := accessor.GetContext(ctx, &previous[i], `select current_setting($1)`, st.name)
+		if err != nil {
+			return err
+		}
+	}
+	var err error
+	for _, st := range settings {
+		_, err = accessor.ExecContext(ctx, `select set_config($1, $2, $3)`, st.name, st.value, local)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		_, err = accessor.ExecContext(ctx, query)
+	}
+	if err != nil && local {
+		// Failed transaction is rolled back along with its settings.
+		return err
+	}
+	for i, st := range settings {
+		_, restoreErr := accessor.ExecContext(ctx, `select set_config($1, $2, $3)`, st.name, previous[i], local)
+		if restoreErr != nil {
+			return multierror.Append(err, restoreErr).ErrorOrNil()
+		}
+	}
 	return err
 }
 
+func (s PostgresStore) defaultTimeouts() timeouts {
+	return timeouts{statement: s.StatementTimeout, lock: s.LockTimeout}
+}
+
+func (s PostgresStore) currentTx() Tx {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.DB
+}
+
 func (s PostgresStore) getDbAccessor() dbAccessor {
 	if s.tx != nil {
 		return s.tx
@@ -130,6 +381,11 @@ func (s PostgresStore) getDbAccessor() dbAccessor {
 	return s.DB
 }
 
+type contextAccessor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+}
+
 type dbAccessor interface {
 	Exec(query string, args ...interface{}) (sql.Result, error)
 	NamedExec(query string, arg interface{}) (sql.Result, error)
@@ -137,30 +393,97 @@ type dbAccessor interface {
 	Get(dest interface{}, query string, args ...interface{}) error
 }
 
+// PostgresStore implements store on top of Postgres database.
+//
+// StatementTimeout and LockTimeout are defaults applied to every migration
+// which does not set own timeouts (see Migration.StatementTimeout). Zero means no limit.
 type PostgresStore struct {
-	DB *sqlx.DB
-	tx *sqlx.Tx
+	DB               *sqlx.DB
+	StatementTimeout time.Duration
+	LockTimeout      time.Duration
+	tx               *sqlx.Tx
+}
+
+// timeouts are limits applied to migration, zero means no limit.
+type timeouts struct {
+	statement time.Duration
+	lock      time.Duration
+}
+
+// settings returns Postgres settings of non-zero limits. Values are rounded up to whole milliseconds,
+// so sub-millisecond limit does not turn into 0 (which Postgres treats as no limit).
+func (t timeouts) settings() []setting {
+	var settings []setting
+	for _, limit := range []setting{
+		{name: "statement_timeout", value: strconv.FormatInt(ceilMilliseconds(t.statement), 10)},
+		{name: "lock_timeout", value: strconv.FormatInt(ceilMilliseconds(t.lock), 10)},
+	} {
+		if limit.value != "0" {
+			settings = append(settings, limit)
+		}
+	}
+	return settings
+}
+
+type setting struct {
+	name  string
+	value string
+}
+
+func ceilMilliseconds(duration time.Duration) int64 {
+	if duration <= 0 {
+		return 0
+	}
+	return int64((duration + time.Millisecond - 1) / time.Millisecond)
 }
 
 type store interface {
 	CreateLogTable() error
-	fetchAllMigrationLogs() ([]migrationLog, error)
+	fetchAllMigrationLogs() ([]MigrationLog, error)
 	fetchLastMigrationSerial() (int, error)
-	insertLogs(logs []migrationLog) error
+	insertLogs(logs []MigrationLog) error
 	fetchLastMigrationIndexes() (map[Repo]int, error)
+	fetchMissingMigrationIndexes() (map[Repo][]int, error)
 	fetchReverseMigrationIndexesAfterSerial(serial int) (map[Repo][]int, error)
-	deleteLogs(logs []migrationLog) error
+	deleteLogs(logs []MigrationLog) error
+	updateLogs(logs []MigrationLog) error
+	insertRepairLogs(logs []repairLog) error
+	fetchSchemaSnapshot() (SchemaSnapshot, error)
+	insertSchemaSnapshot(serial int, snapshot SchemaSnapshot) error
+	fetchLastSchemaSnapshot() (int, SchemaSnapshot, error)
+	deleteSchemaSnapshotsAfterSerial(serial int) error
+	fetchEnvironment() (*Environment, error)
+	saveEnvironment(environment Environment) error
+	currentTx() Tx
+	defaultTimeouts() timeouts
 	begin() error
 	rollback() error
 	commit() error
-	exec(query string) error
+	exec(query string, limits timeouts) error
+}
+
+// MigrationLog is entry of migrations log saved for every applied migration.
+//
+// GapFilled is set for migration applied by Migrate with WithGapFilling option in place of missing one.
+// Such migration gets migration serial higher than migrations with greater indexes.
+type MigrationLog struct {
+	Idx             int       `json:"idx"`
+	Repo            Repo      `json:"repo"`
+	MigrationSerial int       `db:"migration_serial" json:"migrationSerial"`
+	Checksum        string    `json:"checksum"`
+	AppliedAt       time.Time `db:"applied_at" json:"appliedAt"`
+	Description     string    `json:"description"`
+	Version         string    `json:"version"`
+	GapFilled       bool      `db:"gap_filled" json:"gapFilled"`
 }
 
-type migrationLog struct {
-	Idx             int
-	Repo            Repo
-	MigrationSerial int `db:"migration_serial"`
-	Checksum        string
-	AppliedAt       time.Time `db:"applied_at"`
-	Description     string
+type repairLog struct {
+	Idx                 int
+	Repo                Repo
+	PreviousChecksum    string `db:"previous_checksum"`
+	Checksum            string
+	PreviousDescription string `db:"previous_description"`
+	Description         string
+	RepairedBy          string    `db:"repaired_by"`
+	RepairedAt          time.Time `db:"repaired_at"`
 }
@@ -25,7 +25,7 @@ func TestFetchLastMigrationSerial(t *testing.T) {
 	})
 
 	t.Run("Migrations log with one migration returns serial 0, no errors", func(t *testing.T) {
-		assert.NoError(t, th.pgStore.insertLogs([]migrationLog{{
+		assert.NoError(t, th.pgStore.insertLogs([]MigrationLog{{
 			Idx:             0,
 			Repo:            "foo",
 			MigrationSerial: 0,
@@ -38,7 +38,7 @@ func TestFetchLastMigrationSerial(t *testing.T) {
 	})
 
 	t.Run("Migrations log with two migrations returns serial 1, no errors", func(t *testing.T) {
-		assert.NoError(t, th.pgStore.insertLogs([]migrationLog{{
+		assert.NoError(t, th.pgStore.insertLogs([]MigrationLog{{
 			Idx:             1,
 			Repo:            "foo",
 			MigrationSerial: 1,
@@ -51,7 +51,7 @@ func TestFetchLastMigrationSerial(t *testing.T) {
 	})
 }
 func TestIndexesFetch(t *testing.T) {
-	complexMigrationLog := []migrationLog{
+	complexMigrationLog := []MigrationLog{
 		{
 			Idx:             0,
 			Repo:            "foo",
@@ -98,6 +98,21 @@ func TestIndexesFetch(t *testing.T) {
 		assert.Equal(t, map[Repo]int{"foo": 2, "bar": 1}, res)
 	})
 
+	t.Run("TestFetchMissingMigrationIndexes", func(t *testing.T) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+		assert.NoError(t, th.pgStore.insertLogs(complexMigrationLog))
+
+		res, err := th.pgStore.fetchMissingMigrationIndexes()
+		assert.NoError(t, err)
+		assert.Equal(t, map[Repo][]int{}, res)
+
+		assert.NoError(t, th.pgStore.deleteLogs([]MigrationLog{{Idx: 0, Repo: "foo"}, {Idx: 1, Repo: "foo"}}))
+		res, err = th.pgStore.fetchMissingMigrationIndexes()
+		assert.NoError(t, err)
+		assert.Equal(t, map[Repo][]int{"foo": {0, 1}}, res)
+	})
+
 	t.Run("TestFetchReverseMigrationIndexesAfterSerial", func(t *testing.T) {
 		assert.NoError(t, th.resetDB())
 		assert.NoError(t, th.pgStore.CreateLogTable())
@@ -131,7 +146,7 @@ func TestNoDbLog(t *testing.T) {
 	})
 
 	t.Run("deleteLogs", func(t *testing.T) {
-		assert.EqualError(t, th.pgStore.deleteLogs([]migrationLog{{Idx: 0, Repo: "bar"}}), expectedErr)
+		assert.EqualError(t, th.pgStore.deleteLogs([]MigrationLog{{Idx: 0, Repo: "bar"}}), expectedErr)
 	})
 
 	t.Run("fetchLastMigrationIndexes", func(t *testing.T) {
@@ -139,6 +154,11 @@ func TestNoDbLog(t *testing.T) {
 		assert.EqualError(t, err, expectedErr)
 	})
 
+	t.Run("fetchMissingMigrationIndexes", func(t *testing.T) {
+		_, err := th.pgStore.fetchMissingMigrationIndexes()
+		assert.EqualError(t, err, expectedErr)
+	})
+
 	t.Run("fetchLastMigrationSerial", func(t *testing.T) {
 		serial, err := th.pgStore.fetchLastMigrationSerial()
 		assert.EqualError(t, err, expectedErr)
@@ -150,7 +170,7 @@ func TestDeleteLogs(t *testing.T) {
 	assert.NoError(t, th.resetDB())
 	assert.NoError(t, th.pgStore.CreateLogTable())
 
-	assert.NoError(t, th.pgStore.insertLogs([]migrationLog{
+	assert.NoError(t, th.pgStore.insertLogs([]MigrationLog{
 		{
 			Idx:             0,
 			Repo:            "foo",
@@ -166,8 +186,8 @@ func TestDeleteLogs(t *testing.T) {
 			Description:     "",
 		},
 	}))
-	assert.NoError(t, th.pgStore.deleteLogs([]migrationLog{{Idx: 0, Repo: "bar"}}))
-	var migrationLogs []migrationLog
+	assert.NoError(t, th.pgStore.deleteLogs([]MigrationLog{{Idx: 0, Repo: "bar"}}))
+	var migrationLogs []MigrationLog
 	assert.NoError(t, th.db.Select(&migrationLogs, `select * from dbmigrat_log`))
 	assert.Len(t, migrationLogs, 1)
 	assert.Equal(t, 0, migrationLogs[0].Idx)
@@ -0,0 +1,40 @@
+package dbmigrat
+
+import "context"
+
+// Tracer starts spans of Migrate and Rollback runs (see WithTracer option). Its shape follows
+// OpenTelemetry tracer, so it might be backed by one with a thin adapter.
+//
+// Every run gets span named "dbmigrat.Migrate" or "dbmigrat.Rollback" with child span "dbmigrat.migration"
+// for every migration. Migration span has attributes "dbmigrat.repo", "dbmigrat.idx", "dbmigrat.description",
+// "dbmigrat.statement_size" (bytes of Up or Down SQL) and "dbmigrat.serial" (serial of applied or rolled back migration).
+// Rollback span has "dbmigrat.to_serial" attribute set to serial to which migrations are rolled back.
+type Tracer interface {
+	Start(ctx context.Context, spanName string, attributes ...Attribute) (context.Context, Span)
+}
+
+// Span is single operation started by Tracer.
+type Span interface {
+	SetAttributes(attributes ...Attribute)
+	// RecordError records err and marks span as failed.
+	RecordError(err error)
+	End()
+}
+
+// Attribute is key-value pair describing Span. Value is string, int or bool.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, _ string, _ ...Attribute) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttributes(...Attribute) {}
+func (nopSpan) RecordError(error)          {}
+func (nopSpan) End()                       {}
@@ -0,0 +1,124 @@
+package dbmigrat
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	before := func(t *testing.T) (*recordingTracer, context.Context) {
+		assert.NoError(t, th.resetDB())
+		assert.NoError(t, th.pgStore.CreateLogTable())
+		tracer := &recordingTracer{}
+		ctx, _ := tracer.Start(context.Background(), "startup")
+		return tracer, ctx
+	}
+
+	t.Run("starts span of run and every migration", func(t *testing.T) {
+		tracer, ctx := before(t)
+
+		_, err := Migrate(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"}, WithTracer(ctx, tracer))
+		assert.NoError(t, err)
+		_, err = Rollback(th.pgStore, th.migrations1, RepoOrder{"billing"}, -1, WithTracer(ctx, tracer))
+		assert.NoError(t, err)
+
+		createUsers, createOrders := th.migrations1["auth"][0], th.migrations1["billing"][0]
+		assert.Equal(t, []*recordedSpan{
+			{name: "startup"},
+			{name: "dbmigrat.Migrate", parent: "startup", ended: true, attributes: map[string]interface{}{"dbmigrat.serial": 0, "dbmigrat.migrations": 3}},
+			{name: "dbmigrat.migration", parent: "dbmigrat.Migrate", ended: true, attributes: map[string]interface{}{
+				"dbmigrat.repo": "auth", "dbmigrat.idx": 0, "dbmigrat.description": createUsers.Description,
+				"dbmigrat.statement_size": len(createUsers.Up), "dbmigrat.serial": 0,
+			}},
+			{name: "dbmigrat.migration", parent: "dbmigrat.Migrate", ended: true, attributes: map[string]interface{}{
+				"dbmigrat.repo": "auth", "dbmigrat.idx": 1, "dbmigrat.description": th.migrations1["auth"][1].Description,
+				"dbmigrat.statement_size": len(th.migrations1["auth"][1].Up), "dbmigrat.serial": 0,
+			}},
+			{name: "dbmigrat.migration", parent: "dbmigrat.Migrate", ended: true, attributes: map[string]interface{}{
+				"dbmigrat.repo": "billing", "dbmigrat.idx": 0, "dbmigrat.description": createOrders.Description,
+				"dbmigrat.statement_size": len(createOrders.Up), "dbmigrat.serial": 0,
+			}},
+			{name: "dbmigrat.Rollback", parent: "startup", ended: true, attributes: map[string]interface{}{"dbmigrat.to_serial": -1, "dbmigrat.migrations": 1}},
+			{name: "dbmigrat.migration", parent: "dbmigrat.Rollback", ended: true, attributes: map[string]interface{}{
+				"dbmigrat.repo": "billing", "dbmigrat.idx": 0, "dbmigrat.description": createOrders.Description,
+				"dbmigrat.statement_size": len(createOrders.Down), "dbmigrat.serial": 0,
+			}},
+		}, tracer.spans)
+	})
+
+	t.Run("rolled back migrations have serials they were applied with", func(t *testing.T) {
+		tracer, ctx := before(t)
+		_, err := Migrate(th.pgStore, th.migrations1, RepoOrder{"auth", "billing"})
+		assert.NoError(t, err)
+		_, err = Migrate(th.pgStore, th.migrations2, RepoOrder{"auth", "billing", "delivery"})
+		assert.NoError(t, err)
+
+		_, err = Rollback(th.pgStore, th.migrations2, RepoOrder{"delivery", "billing", "auth"}, -1, WithTracer(ctx, tracer))
+		assert.NoError(t, err)
+
+		assert.Len(t, tracer.spans, 7)
+		assert.Equal(t, -1, tracer.spans[1].attributes["dbmigrat.to_serial"])
+		var serials []interface{}
+		for _, span := range tracer.spans[2:] {
+			assert.Equal(t, "dbmigrat.Rollback", span.parent)
+			serials = append(serials, span.attributes["dbmigrat.serial"])
+		}
+		assert.Equal(t, []interface{}{1, 1, 0, 0, 0}, serials)
+	})
+
+	t.Run("marks failed spans", func(t *testing.T) {
+		tracer, ctx := before(t)
+		failing := Migrations{"auth": {{Up: `alter table non_existing add column id integer`, Description: "failing"}}}
+
+		_, err := Migrate(th.pgStore, failing, RepoOrder{"auth"}, WithTracer(ctx, tracer))
+		assert.Error(t, err)
+		assert.Len(t, tracer.spans, 3)
+		for _, span := range tracer.spans[1:] {
+			assert.True(t, span.ended, span.name)
+			assert.Error(t, span.err, span.name)
+		}
+	})
+}
+
+// recordingTracer is in-process exporter which keeps started spans in order.
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	name       string
+	parent     string
+	attributes map[string]interface{}
+	err        error
+	ended      bool
+}
+
+type spanCtxKey struct{}
+
+func (rt *recordingTracer) Start(ctx context.Context, spanName string, attributes ...Attribute) (context.Context, Span) {
+	span := &recordedSpan{name: spanName}
+	if parent, ok := ctx.Value(spanCtxKey{}).(*recordedSpan); ok {
+		span.parent = parent.name
+	}
+	span.SetAttributes(attributes...)
+	rt.spans = append(rt.spans, span)
+	return context.WithValue(ctx, spanCtxKey{}, span), span
+}
+
+func (rs *recordedSpan) SetAttributes(attributes ...Attribute) {
+	for _, attribute := range attributes {
+		if rs.attributes == nil {
+			rs.attributes = map[string]interface{}{}
+		}
+		rs.attributes[attribute.Key] = attribute.Value
+	}
+}
+
+func (rs *recordedSpan) RecordError(err error) {
+	rs.err = err
+}
+
+func (rs *recordedSpan) End() {
+	rs.ended = true
+}